@@ -0,0 +1,400 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/CrossR/kb_ui/tray"
+)
+
+// A single subcommand. Offline commands are run without connecting to the
+// running tray, so are passed a nil client.
+type command struct {
+	minArgs int
+	maxArgs int
+	offline bool
+	run     func(client *tray.ControlClient, args []string, opts options) error
+}
+
+// The flags shared by every command, though most only use --json.
+type options struct {
+	json     bool
+	since    string
+	format   string
+	keyboard string
+}
+
+var commands = map[string]command{
+	"status":         {0, 0, false, runStatus},
+	"layers":         {0, 0, false, runLayers},
+	"set-layer":      {1, 1, false, runSetLayer},
+	"toggle-connect": {0, 0, false, runToggleConnect},
+	"watch":          {0, 0, false, runWatch},
+	"validate":       {0, 1, true, runValidate},
+	"stats":          {0, 0, true, runStats},
+	"help":           {0, 0, true, nil},
+}
+
+// Parse the command line, connect to the running tray, then run the command.
+func runCommand(cmd command, args []string) error {
+
+	flags := flag.NewFlagSet("kb_ui", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	opts := options{}
+	flags.BoolVar(&opts.json, "json", false, "print JSON output")
+	flags.StringVar(&opts.since, "since", "7d", "how far back to report")
+	flags.StringVar(&opts.format, "format", "table", "the report format")
+	flags.StringVar(&opts.keyboard, "keyboard", "", "the keyboard to change")
+
+	// Allow flags before or after the positional arguments.
+	positional := []string{}
+	for {
+		err := flags.Parse(args)
+
+		if err != nil {
+			return fmt.Errorf("%s\n\n%s", err.Error(), usage)
+		}
+
+		if flags.NArg() == 0 {
+			break
+		}
+
+		positional = append(positional, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+
+	if cmd.run == nil {
+		fmt.Print(usage)
+		return nil
+	}
+
+	if len(positional) < cmd.minArgs || len(positional) > cmd.maxArgs {
+		return errors.New(usage)
+	}
+
+	if cmd.offline {
+		return cmd.run(nil, positional, opts)
+	}
+
+	client, err := tray.DialControl()
+
+	if err != nil {
+		return err
+	}
+
+	defer client.Close()
+
+	return cmd.run(client, positional, opts)
+}
+
+func printJson(value any) error {
+	return json.NewEncoder(os.Stdout).Encode(value)
+}
+
+func formatState(state *tray.SaveState) string {
+
+	// With more than one keyboard, show each on its own line.
+	if len(state.Keyboards) != 0 {
+		lines := []string{}
+
+		for _, keyboard := range state.Keyboards {
+			lines = append(lines, fmt.Sprintf("%s: %s", keyboard.Keyboard, formatState(&keyboard)))
+		}
+
+		return strings.Join(lines, "\n")
+	}
+
+	connection := "connected"
+	if !state.IsConnected {
+		connection = "disconnected"
+	}
+
+	// Show every flag that is on next to the connection, i.e. (connected, caps-word).
+	flags := []string{}
+	for name, on := range state.Flags {
+		if on {
+			flags = append(flags, name)
+		}
+	}
+
+	sort.Strings(flags)
+	// The output goes straight after the connection, i.e. (connected, BT1).
+	if state.Output != "" {
+		connection = fmt.Sprintf("%s, %s", connection, state.Output)
+	}
+
+	connection = strings.Join(append([]string{connection}, flags...), ", ")
+
+	// With layer groups, show the active layer of each.
+	if len(state.Groups) != 0 {
+		names := []string{}
+		for _, group := range state.Groups {
+			names = append(names, group.Layer)
+		}
+
+		return fmt.Sprintf("%s (%s)", strings.Join(names, " · "), connection)
+	}
+
+	// Only show the stack when there is something under the current layer.
+	if len(state.Stack) > 1 {
+		return fmt.Sprintf("%s Layer (%s), stack: %s", state.LayerName, connection, strings.Join(state.Stack, " > "))
+	}
+
+	return fmt.Sprintf("%s Layer (%s)", state.LayerName, connection)
+}
+
+func runStatus(client *tray.ControlClient, args []string, opts options) error {
+
+	response, err := client.Send(tray.ControlRequest{Command: "get_state"})
+
+	if err != nil {
+		return err
+	}
+
+	if opts.json {
+		return printJson(response.State)
+	}
+
+	fmt.Println(formatState(response.State))
+
+	return nil
+}
+
+func runLayers(client *tray.ControlClient, args []string, opts options) error {
+
+	response, err := client.Send(tray.ControlRequest{Command: "list_layers"})
+
+	if err != nil {
+		return err
+	}
+
+	if opts.json {
+		return printJson(response.Layers)
+	}
+
+	current, err := client.Send(tray.ControlRequest{Command: "get_state"})
+
+	if err != nil {
+		return err
+	}
+
+	// The current layer of each keyboard, by keyboard name.
+	layers := map[string]int{current.State.Keyboard: current.State.LayerId}
+	for _, keyboard := range current.State.Keyboards {
+		layers[keyboard.Keyboard] = keyboard.LayerId
+	}
+
+	for _, layer := range response.Layers {
+		marker := " "
+		if id, ok := layers[layer.Keyboard]; ok && id == layer.Id {
+			marker = "*"
+		}
+
+		if len(current.State.Keyboards) != 0 {
+			fmt.Printf("%s %s %d: %s\n", marker, layer.Keyboard, layer.Id, layer.Name)
+		} else {
+			fmt.Printf("%s %d: %s\n", marker, layer.Id, layer.Name)
+		}
+	}
+
+	return nil
+}
+
+func runSetLayer(client *tray.ControlClient, args []string, opts options) error {
+
+	response, err := client.Send(tray.ControlRequest{Command: "set_layer", Layer: args[0], Keyboard: opts.keyboard})
+
+	if err != nil {
+		return err
+	}
+
+	if opts.json {
+		return printJson(response)
+	}
+
+	// Name the layer as the tray does, since it may have been given by id.
+	layer := args[0]
+
+	if state := response.State; state != nil {
+		layer = state.LayerName
+
+		for _, keyboard := range state.Keyboards {
+			if keyboard.Keyboard == opts.keyboard {
+				layer = keyboard.LayerName
+			}
+		}
+	}
+
+	fmt.Printf("Swapped to %s Layer\n", layer)
+
+	return nil
+}
+
+func runToggleConnect(client *tray.ControlClient, args []string, opts options) error {
+
+	response, err := client.Send(tray.ControlRequest{Command: "toggle_connect", Keyboard: opts.keyboard})
+
+	if err != nil {
+		return err
+	}
+
+	if opts.json {
+		return printJson(response)
+	}
+
+	fmt.Println("Toggled connection")
+
+	return nil
+}
+
+func runWatch(client *tray.ControlClient, args []string, opts options) error {
+
+	response, err := client.Send(tray.ControlRequest{Command: "subscribe"})
+
+	for err == nil {
+		if opts.json {
+			err = printJson(response.State)
+		} else {
+			fmt.Println(formatState(response.State))
+		}
+
+		if err == nil {
+			response, err = client.Next()
+		}
+	}
+
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+
+	return err
+}
+
+func runValidate(client *tray.ControlClient, args []string, opts options) error {
+
+	path := tray.ConfigPath()
+	if len(args) == 1 {
+		path = args[0]
+	}
+
+	file, err := os.ReadFile(path)
+
+	if err != nil {
+		return err
+	}
+
+	problems := tray.ValidateConfigFile(file)
+
+	if opts.json {
+		err = printJson(problems)
+	} else {
+		for _, problem := range problems {
+			fmt.Printf("%s: %s\n", path, problem.Error())
+		}
+	}
+
+	if err != nil {
+		return err
+	} else if len(problems) != 0 {
+		return fmt.Errorf("found %d problems in %s", len(problems), path)
+	}
+
+	if !opts.json {
+		fmt.Printf("%s is valid\n", path)
+	}
+
+	return nil
+}
+
+func runStats(client *tray.ControlClient, args []string, opts options) error {
+
+	since, err := tray.ParseSince(opts.since, time.Now())
+
+	if err != nil {
+		return err
+	}
+
+	stats, err := tray.LoadStats()
+
+	if err != nil {
+		return err
+	}
+
+	report := stats.Report(since)
+
+	format := opts.format
+	if opts.json {
+		format = "json"
+	}
+
+	switch format {
+	case "json":
+		return printJson(report)
+	case "csv":
+		return printStatsCsv(report)
+	case "table":
+		printStatsTable(report)
+		return nil
+	}
+
+	return fmt.Errorf("unknown format %q, should be table, json or csv", format)
+}
+
+func printStatsTable(report tray.StatsReport) {
+
+	fmt.Printf("Since %s\n\n", report.Since.Format("2006-01-02 15:04"))
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "LAYER\tTIME\tSWITCHES\tBUSIEST HOUR")
+
+	for _, layer := range report.Layers {
+		busiest := 0
+		for hour, seconds := range layer.Hours {
+			if seconds > layer.Hours[busiest] {
+				busiest = hour
+			}
+		}
+
+		fmt.Fprintf(writer, "%s\t%s\t%d\t%02d:00\n", layer.Name, tray.FormatDwell(layer.Seconds), layer.Switches, busiest)
+	}
+
+	writer.Flush()
+
+	fmt.Printf("\nConnected %d times, disconnected %d times\n", report.Connects, report.Disconnects)
+}
+
+// One row per layer, with the seconds spent in it during each hour of the day.
+func printStatsCsv(report tray.StatsReport) error {
+
+	writer := csv.NewWriter(os.Stdout)
+	header := []string{"layer", "seconds", "switches"}
+
+	for hour := 0; hour < 24; hour++ {
+		header = append(header, fmt.Sprintf("hour_%02d", hour))
+	}
+
+	writer.Write(header)
+
+	for _, layer := range report.Layers {
+		row := []string{layer.Name, fmt.Sprintf("%.0f", layer.Seconds), fmt.Sprint(layer.Switches)}
+
+		for _, seconds := range layer.Hours {
+			row = append(row, fmt.Sprintf("%.0f", seconds))
+		}
+
+		writer.Write(row)
+	}
+
+	writer.Flush()
+
+	return writer.Error()
+}
@@ -5,9 +5,13 @@ go 1.22
 require (
 	github.com/adrg/xdg v0.4.0
 	github.com/getlantern/systray v1.2.2
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/skratchdot/open-golang v0.0.0-20200116055534-eef842397966
+	github.com/srwiley/oksvg v0.0.0-20221011165216-be6e8873101c
+	github.com/srwiley/rasterx v0.0.0-20220730225603-2ab79fcdd4ef
 	golang.design/x/hotkey v0.4.1
 	golang.org/x/exp v0.0.0-20240506185415-9bf2ced13842
+	golang.org/x/image v0.18.0
 )
 
 require (
@@ -26,5 +30,7 @@ require (
 	go.opentelemetry.io/otel/trace v1.26.0 // indirect
 	go.uber.org/multierr v1.11.0 // indirect
 	go.uber.org/zap v1.27.0 // indirect
+	golang.org/x/net v0.0.0-20211118161319-6a13c67c3ce4 // indirect
 	golang.org/x/sys v0.20.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
 )
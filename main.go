@@ -1,7 +1,53 @@
 package main
 
-import "github.com/CrossR/kb_ui/tray"
+import (
+	"fmt"
+	"os"
+
+	"github.com/CrossR/kb_ui/tray"
+)
+
+const usage = `Usage: kb_ui [command] [--json]
+
+With no command, start the tray.
+
+Commands:
+  status             Show the current layer and connection state
+  layers             List the configured layers
+  set-layer <layer>  Swap to the given layer, by name or index
+                     [--keyboard name]
+  toggle-connect     Toggle the output connection state [--keyboard name]
+  watch              Print every layer or connection change
+  validate [file]    Check a config file, without starting the tray
+  stats              Show how long was spent in each layer
+                     [--since 7d|12h|2024-05-01] [--format table|json|csv]
+  help               Show this message
+`
 
 func main() {
-	tray.Start()
+
+	if len(os.Args) < 2 {
+		err := tray.Start()
+
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	command, ok := commands[os.Args[1]]
+
+	if !ok {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	err := runCommand(command, os.Args[2:])
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
 }
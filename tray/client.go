@@ -0,0 +1,91 @@
+package tray
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+)
+
+// A connection to the control socket of an already running tray.
+type ControlClient struct {
+	conn    net.Conn
+	scanner *bufio.Scanner
+}
+
+var ErrNotRunning = errors.New("kb_ui is not running")
+
+// Connect to the running tray instance.
+func DialControl() (*ControlClient, error) {
+
+	path, err := ControlSocketPath()
+
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.Dial("unix", path)
+
+	if err != nil {
+		return nil, ErrNotRunning
+	}
+
+	return &ControlClient{conn, bufio.NewScanner(conn)}, nil
+}
+
+// Check if there is already a tray instance serving the control socket.
+func IsRunning() bool {
+
+	client, err := DialControl()
+
+	if err != nil {
+		return false
+	}
+
+	client.Close()
+
+	return true
+}
+
+// Send a single request, and wait for its response.
+// A response that is not ok is returned as an error.
+func (client *ControlClient) Send(request ControlRequest) (ControlResponse, error) {
+
+	err := json.NewEncoder(client.conn).Encode(request)
+
+	if err != nil {
+		return ControlResponse{}, err
+	}
+
+	return client.Next()
+}
+
+// Read the next response, i.e. the next state change after a subscribe.
+func (client *ControlClient) Next() (ControlResponse, error) {
+
+	if !client.scanner.Scan() {
+		if client.scanner.Err() != nil {
+			return ControlResponse{}, client.scanner.Err()
+		}
+
+		return ControlResponse{}, io.EOF
+	}
+
+	response := ControlResponse{}
+	err := json.Unmarshal(client.scanner.Bytes(), &response)
+
+	if err != nil {
+		return ControlResponse{}, err
+	}
+
+	if !response.Ok {
+		return response, errors.New(response.Error)
+	}
+
+	return response, nil
+}
+
+func (client *ControlClient) Close() error {
+	return client.conn.Close()
+}
@@ -7,28 +7,212 @@ import (
 
 	"github.com/adrg/xdg"
 	"github.com/skratchdot/open-golang/open"
+	"golang.org/x/exp/slices"
 )
 
 type LayerConfig struct {
-	Key      string `json:"key"`
-	Mods     string `json:"mods"`
-	Name     string `json:"name"`
-	Icon     string `json:"icon"`
-	DarkIcon string `json:"dark_icon,omitempty"`
+	Key       string   `json:"key"`
+	Mods      string   `json:"mods"`
+	Name      string   `json:"name"`
+	Icon      IconSpec `json:"icon"`
+	DarkIcon  IconSpec `json:"dark_icon,omitempty"`
+	Momentary bool     `json:"momentary,omitempty"`
+	Behavior  string   `json:"behavior,omitempty"`
+	OnEnter   []string `json:"on_enter,omitempty"`
+	OnExit    []string `json:"on_exit,omitempty"`
+	Notify    bool     `json:"notify,omitempty"`
+	Urgency   string   `json:"urgency,omitempty"`
+	Group     string   `json:"group,omitempty"`
 }
 
+// An icon to show while every one of the given layers is active, i.e. one icon
+// for being in both the Mac and Gaming layers.
+type ComboConfig struct {
+	Layers   []string `json:"layers"`
+	Icon     IconSpec `json:"icon"`
+	DarkIcon IconSpec `json:"dark_icon,omitempty"`
+}
+
+// A single key chord, i.e. {"mods": "ctrl-shift", "key": "F13"}.
+type ChordConfig struct {
+	Mods string `json:"mods"`
+	Key  string `json:"key"`
+}
+
+// A named setting of the keyboard that is either on or off, like caps-word or
+// mouse keys. Its icon is drawn over the layer icon while it is on, and its
+// off_icon while it is off. With replace, the icon is shown in place of the
+// layer icon instead.
+type FlagConfig struct {
+	Name    string       `json:"name"`
+	Set     *ChordConfig `json:"set,omitempty"`
+	Clear   *ChordConfig `json:"clear,omitempty"`
+	Toggle  *ChordConfig `json:"toggle,omitempty"`
+	Icon    IconSpec     `json:"icon,omitempty"`
+	OffIcon IconSpec     `json:"off_icon,omitempty"`
+	Replace bool         `json:"replace,omitempty"`
+}
+
+// An output the keyboard can type into, i.e. USB or a Bluetooth profile, which
+// is picked by its chord. Its icon is drawn over the layer icon while it is
+// active, or shown in place of it with replace. If connected is given, picking
+// the output also sets the connected flag, i.e. to false for the profile of
+// another computer.
+type OutputConfig struct {
+	Name      string   `json:"name"`
+	Mods      string   `json:"mods,omitempty"`
+	Key       string   `json:"key,omitempty"`
+	Icon      IconSpec `json:"icon,omitempty"`
+	Replace   bool     `json:"replace,omitempty"`
+	Connected *bool    `json:"connected,omitempty"`
+}
+
+// A single keyboard, with its own layers, flags and icons.
+// The connect fields are from before flags, and are the same as setting up
+// the connected flag. The set keys use connectMods, unless given their own.
+type KeyboardConfig struct {
+	Name              string         `json:"name,omitempty"`
+	LayerInfo         []LayerConfig  `json:"layers"`
+	ConnectMods       string         `json:"connectMods,omitempty"`
+	ConnectKey        string         `json:"connectKey,omitempty"`
+	ConnectSetMods    string         `json:"connectSetMods,omitempty"`
+	ConnectSetKey     string         `json:"connectSetKey,omitempty"`
+	DisconnectSetMods string         `json:"disconnectSetMods,omitempty"`
+	DisconnectSetKey  string         `json:"disconnectSetKey,omitempty"`
+	DisconnectIcon    IconSpec       `json:"disconnectIcon,omitempty"`
+	HidDevice         string         `json:"hidDevice,omitempty"`
+	LedDevice         string         `json:"ledDevice,omitempty"`
+	OnConnect         []string       `json:"on_connect,omitempty"`
+	OnDisconnect      []string       `json:"on_disconnect,omitempty"`
+	NotifyConnect     bool           `json:"notifyConnect,omitempty"`
+	Combos            []ComboConfig  `json:"combos,omitempty"`
+	Flags             []FlagConfig   `json:"flags,omitempty"`
+	Outputs           []OutputConfig `json:"outputs,omitempty"`
+}
+
+// Get every flag of the keyboard. The connected flag is always there, and is
+// built from the older connect fields if it isn't given in the flags list.
+// With a led device, the caps and num lock flags are always there too.
+func (config *KeyboardConfig) FlagConfigs() []FlagConfig {
+
+	flags := config.Flags
+
+	if !config.hasFlag(ConnectFlag) {
+		flags = append([]FlagConfig{config.connectFlag()}, flags...)
+	}
+
+	if config.LedDevice == "" {
+		return flags
+	}
+
+	defaultLeds := []FlagConfig{
+		{Name: CapsLockFlag, Icon: IconSpec{Text: "A", Shape: "circle"}},
+		{Name: NumLockFlag, Icon: IconSpec{Text: "1", Shape: "circle"}},
+	}
+
+	for _, led := range defaultLeds {
+		if !config.hasFlag(led.Name) {
+			flags = append(flags, led)
+		}
+	}
+
+	return flags
+}
+
+// Check if a flag is given in the flags list.
+func (config *KeyboardConfig) hasFlag(name string) bool {
+	return slices.ContainsFunc(config.Flags, func(flag FlagConfig) bool {
+		return flag.Name == name
+	})
+}
+
+// Build the connected flag from the older connect fields.
+func (config *KeyboardConfig) connectFlag() FlagConfig {
+
+	connect := FlagConfig{Name: ConnectFlag, OffIcon: config.DisconnectIcon, Replace: true}
+
+	if connect.OffIcon.IsEmpty() {
+		connect.OffIcon = IconSpec{Name: "disconnected"}
+	}
+
+	if config.ConnectKey != "" {
+		connect.Toggle = &ChordConfig{config.ConnectMods, config.ConnectKey}
+	}
+
+	connect.Set = config.connectChord(config.ConnectSetMods, config.ConnectSetKey)
+	connect.Clear = config.connectChord(config.DisconnectSetMods, config.DisconnectSetKey)
+
+	return connect
+}
+
+// Get one of the older connect chords, which uses connectMods unless it has
+// its own.
+func (config *KeyboardConfig) connectChord(mods string, key string) *ChordConfig {
+
+	if key == "" {
+		return nil
+	} else if mods == "" {
+		mods = config.ConnectMods
+	}
+
+	return &ChordConfig{mods, key}
+}
+
+// Check if any of the older connect fields are used.
+func (config *KeyboardConfig) hasConnectFields() bool {
+	return config.ConnectMods != "" || config.ConnectKey != "" ||
+		config.ConnectSetKey != "" || config.DisconnectSetKey != "" ||
+		config.ConnectSetMods != "" || config.DisconnectSetMods != "" ||
+		!config.DisconnectIcon.IsEmpty()
+}
+
+// A single keyboard can be set up at the top level of the config, as older
+// configs are, or any number of keyboards can be given in the keyboards list.
 type Config struct {
-	LayerInfo      []LayerConfig `json:"layers"`
-	ConnectMods    string        `json:"connectMods,omitempty"`
-	ConnectKey     string        `json:"connectKey,omitempty"`
-	DisconnectIcon string        `json:"disconnectIcon,omitempty"`
-	DarkMode       bool          `json:"darkMode,omitempty"`
+	KeyboardConfig
+	Keyboards []KeyboardConfig `json:"keyboards,omitempty"`
+	DarkMode  bool             `json:"darkMode,omitempty"`
 }
 
-func LoadConfiguration() (Config, error) {
-	cfg := Config{}
+// Get every keyboard in the config. Without a keyboards list, the top level of
+// the config is the only keyboard.
+func (config *Config) KeyboardConfigs() []*KeyboardConfig {
+
+	if len(config.Keyboards) == 0 {
+		return []*KeyboardConfig{&config.KeyboardConfig}
+	}
+
+	keyboards := []*KeyboardConfig{}
+	for i := range config.Keyboards {
+		keyboards = append(keyboards, &config.Keyboards[i])
+	}
+
+	return keyboards
+}
+
+// Check if any keyboard has a layer defined.
+func (config *Config) HasLayers() bool {
+
+	for _, keyboard := range config.KeyboardConfigs() {
+		if len(keyboard.LayerInfo) != 0 {
+			return true
+		}
+	}
+
+	return false
+}
 
+// Get the path of the user config file.
+func ConfigPath() string {
 	configFile, _ := xdg.ConfigFile("/kb_ui/config.json")
+	return configFile
+}
+
+// Load the user config, creating the default one if there isn't one yet,
+// along with any problems found in it.
+func LoadConfiguration() (Config, []ConfigError, error) {
+
+	configFile := ConfigPath()
 
 	if _, err := os.Stat(configFile); errors.Is(err, os.ErrNotExist) {
 		initConfig()
@@ -37,10 +221,27 @@ func LoadConfiguration() (Config, error) {
 	file, err := os.ReadFile(configFile)
 
 	if err != nil {
-		return Config{}, err
+		return Config{}, nil, err
 	}
 
-	json.Unmarshal(file, &cfg)
+	cfg, err := ParseConfig(file)
+
+	if err != nil {
+		return Config{}, nil, err
+	}
+
+	return cfg, ValidateConfigFile(file), nil
+}
+
+// Parse the raw JSON of a config file.
+func ParseConfig(file []byte) (Config, error) {
+
+	cfg := Config{}
+	err := json.Unmarshal(file, &cfg)
+
+	if err != nil {
+		return Config{}, err
+	}
 
 	return cfg, nil
 }
@@ -48,13 +249,16 @@ func LoadConfiguration() (Config, error) {
 func initConfig() {
 
 	defaultBind := []LayerConfig{
-		{"1", "ctrl-shift-win-alt", "Gaming", "kb_light", "kb_dark"},
+		{Key: "1", Mods: "ctrl-shift-win-alt", Name: "Gaming", Icon: IconSpec{Name: "kb_light"}, DarkIcon: IconSpec{Name: "kb_dark"}},
+	}
+	defaultFlags := []FlagConfig{
+		{Name: ConnectFlag, Toggle: &ChordConfig{"ctrl-shift-win-alt", "9"}, OffIcon: IconSpec{Name: "disconnected"}, Replace: true},
 	}
 	defaultConfig := Config{
-		defaultBind,
-		"ctrl-shift-win-alt", "9",
-		"disconnected",
-		false,
+		KeyboardConfig: KeyboardConfig{
+			LayerInfo: defaultBind,
+			Flags:     defaultFlags,
+		},
 	}
 
 	json, err := json.MarshalIndent(defaultConfig, "", "    ")
@@ -63,8 +267,7 @@ func initConfig() {
 		return
 	}
 
-	configFile, _ := xdg.ConfigFile("/kb_ui/config.json")
-	err = os.WriteFile(configFile, json, 0644)
+	err = os.WriteFile(ConfigPath(), json, 0644)
 
 	if err != nil {
 		return
@@ -74,22 +277,24 @@ func initConfig() {
 
 // Open the configuration file in the default reader for the JSON file type.
 func OpenConfig() {
-	configFile, _ := xdg.ConfigFile("/kb_ui/config.json")
-	open.Start(configFile)
+	open.Start(ConfigPath())
 }
 
 func LoadConfig(state *TrayState) *Config {
-	configFile, _ := xdg.ConfigFile("/kb_ui/config.json")
 
-	file, err := os.ReadFile(configFile)
+	file, err := os.ReadFile(ConfigPath())
 
 	if err != nil {
 		state.logger.Printf("Failed to load config: %s\n", err.Error())
 		return nil
 	}
 
-	cfg := Config{}
-	json.Unmarshal(file, &cfg)
+	cfg, err := ParseConfig(file)
+
+	if err != nil {
+		state.logger.Printf("Failed to parse config: %s\n", err.Error())
+		return nil
+	}
 
 	return &cfg
 }
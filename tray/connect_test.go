@@ -0,0 +1,135 @@
+package tray
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// The connected flag is built from the older connect fields, with the set and
+// clear chords using connectMods unless they have their own.
+func TestConnectFlagConfig(t *testing.T) {
+
+	chord := func(mods string, key string) *ChordConfig {
+		return &ChordConfig{mods, key}
+	}
+
+	tests := []struct {
+		name    string
+		config  string
+		toggle  *ChordConfig
+		set     *ChordConfig
+		clear   *ChordConfig
+		offIcon IconSpec
+	}{
+		{"nothing", `{}`, nil, nil, nil, IconSpec{Name: "disconnected"}},
+		{"toggle", `{"connectMods": "ctrl-shift", "connectKey": "F12"}`,
+			chord("ctrl-shift", "F12"), nil, nil, IconSpec{Name: "disconnected"}},
+		{"set and clear", `{"connectMods": "ctrl-shift", "connectSetKey": "F10", "disconnectSetKey": "F11"}`,
+			nil, chord("ctrl-shift", "F10"), chord("ctrl-shift", "F11"), IconSpec{Name: "disconnected"}},
+		{"own mods", `{"connectMods": "ctrl-shift", "connectKey": "F12", "connectSetMods": "alt", "connectSetKey": "F10", "disconnectSetMods": "win", "disconnectSetKey": "F11"}`,
+			chord("ctrl-shift", "F12"), chord("alt", "F10"), chord("win", "F11"), IconSpec{Name: "disconnected"}},
+		{"disconnect icon", `{"disconnectIcon": {"text": "X"}}`,
+			nil, nil, nil, IconSpec{Text: "X"}},
+	}
+
+	equal := func(a *ChordConfig, b *ChordConfig) bool {
+		return a == nil && b == nil || a != nil && b != nil && *a == *b
+	}
+
+	for _, test := range tests {
+		config := KeyboardConfig{}
+		if err := json.Unmarshal([]byte(test.config), &config); err != nil {
+			t.Errorf("%s: failed to parse: %s", test.name, err)
+			continue
+		}
+
+		flags := config.FlagConfigs()
+		if len(flags) != 1 || flags[0].Name != ConnectFlag {
+			t.Errorf("%s: expected just the connected flag, got %+v", test.name, flags)
+			continue
+		}
+
+		connect := flags[0]
+
+		if !equal(connect.Toggle, test.toggle) || !equal(connect.Set, test.set) || !equal(connect.Clear, test.clear) {
+			t.Errorf("%s: got toggle %v, set %v, clear %v, want %v, %v, %v", test.name,
+				connect.Toggle, connect.Set, connect.Clear, test.toggle, test.set, test.clear)
+		}
+
+		if connect.OffIcon != test.offIcon || !connect.Replace {
+			t.Errorf("%s: expected the off icon %v to replace the layer icon, got %+v", test.name, test.offIcon, connect)
+		}
+	}
+
+	// A connected flag in the flags list is used as is.
+	config := KeyboardConfig{ConnectKey: "F12", Flags: []FlagConfig{{Name: ConnectFlag, Set: chord("alt", "F1")}}}
+	if flags := config.FlagConfigs(); len(flags) != 1 || flags[0].Toggle != nil || !equal(flags[0].Set, chord("alt", "F1")) {
+		t.Errorf("expected the listed connected flag to be kept, got %+v", flags)
+	}
+}
+
+// Setting the connection is absolute, so a missed chord can't leave it
+// inverted, unlike the toggle.
+func TestConnectChanged(t *testing.T) {
+
+	state := newTestState(t, `{"layers": `+testLayers+`, "connectSetKey": "F10", "disconnectSetKey": "F11", "connectMods": "ctrl-shift"}`)
+
+	steps := []struct {
+		event LayerEvent
+		want  bool
+	}{
+		{LayerEvent{Kind: ConnectChanged, IsConnected: false}, false},
+		{LayerEvent{Kind: ConnectChanged, IsConnected: false}, false},
+		{LayerEvent{Kind: ConnectChanged, IsConnected: true}, true},
+		{LayerEvent{Kind: ConnectChanged, IsConnected: true}, true},
+		{LayerEvent{Kind: ConnectToggled}, false},
+		{LayerEvent{Kind: ConnectToggled}, true},
+	}
+
+	for i, step := range steps {
+		var connected bool
+		var saved SaveState
+
+		state.loop.Do(func() {
+			state.HandleEvent(step.event)
+			connected = state.keyboards[0].connected()
+			saved = state.keyboards[0].savedState()
+		})
+
+		if connected != step.want || saved.IsConnected != step.want {
+			t.Errorf("step %d: expected connected to be %t, got %t (saved %+v)", i, step.want, connected, saved)
+		}
+	}
+}
+
+// The connected flag gets a menu checkbox like any other, to correct it by
+// hand, and it follows the chords too.
+func TestConnectMenuCheckbox(t *testing.T) {
+
+	state := newTestState(t, `{"layers": `+testLayers+`, "connectMods": "ctrl-shift", "connectKey": "F12"}`)
+
+	keyboard := state.keyboards[0]
+	source := NewMenuSource(state, keyboard)
+
+	state.loop.Do(func() { state.RunSource(source) })
+	defer state.loop.Do(func() { source.Stop() })
+
+	flag := keyboard.FindFlag(ConnectFlag)
+
+	var item bool
+	state.loop.Do(func() { item = flag.item != nil && flag.item.Checked() })
+
+	if !item {
+		t.Fatalf("expected a checked connected item")
+	}
+
+	flag.item.ClickedCh <- struct{}{}
+	waitForState(t, state, "the click to disconnect", func() bool {
+		return !keyboard.connected() && !flag.item.Checked()
+	})
+
+	state.loop.Do(func() { state.HandleEvent(LayerEvent{Kind: ConnectChanged, IsConnected: true}) })
+	waitForState(t, state, "the chord to connect", func() bool {
+		return keyboard.connected() && flag.item.Checked()
+	})
+}
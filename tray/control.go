@@ -0,0 +1,345 @@
+package tray
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/adrg/xdg"
+)
+
+// The control socket speaks line delimited JSON. Every request is a single
+// JSON object on its own line, and gets a single ControlResponse line back:
+//
+//	{"command": "get_state"}
+//	{"command": "set_layer", "layer": "Gaming"}
+//	{"command": "set_layer", "layer": "1"}
+//	{"command": "set_layer", "layer": "Gaming", "keyboard": "Sofle"}
+//	{"command": "toggle_connect", "keyboard": "Sofle"}
+//	{"command": "list_layers"}
+//	{"command": "subscribe"}
+//
+// With more than one keyboard, set_layer and toggle_connect take the name of
+// the keyboard to change, and go to the first keyboard without one. Both reply
+// with the state once the change has been made.
+//
+// After a subscribe, the connection is only used to stream state changes, one
+// ControlResponse per change, starting with the current state.
+
+// A request to the control socket. Keyboard picks which keyboard the request
+// is for, and is the first keyboard if not given.
+type ControlRequest struct {
+	Command  string `json:"command"`
+	Layer    string `json:"layer,omitempty"`
+	Keyboard string `json:"keyboard,omitempty"`
+}
+
+type ControlLayer struct {
+	Id       int    `json:"id"`
+	Name     string `json:"name"`
+	Keyboard string `json:"keyboard,omitempty"`
+}
+
+type ControlResponse struct {
+	Ok     bool           `json:"ok"`
+	Error  string         `json:"error,omitempty"`
+	State  *SaveState     `json:"state,omitempty"`
+	Layers []ControlLayer `json:"layers,omitempty"`
+}
+
+// How many state changes can queue up for a slow subscriber before they
+// start being dropped.
+const controlSubscriberBuffer = 64
+
+// Get the path of the control socket for the running tray.
+func ControlSocketPath() (string, error) {
+	return xdg.RuntimeFile("kb_ui/kb_ui.sock")
+}
+
+// A local control socket, so scripts can read and set the current layer
+// without sending fake key chords.
+type ControlServer struct {
+	state       *TrayState
+	path        string
+	listener    net.Listener
+	done        chan struct{}
+	running     sync.WaitGroup
+	lock        sync.Mutex
+	conns       map[net.Conn]bool
+	subscribers map[chan SaveState]bool
+}
+
+func NewControlServer(state *TrayState) *ControlServer {
+
+	server := &ControlServer{state: state}
+	state.OnChange(server.broadcast)
+
+	return server
+}
+
+func (server *ControlServer) Start() error {
+
+	path, err := ControlSocketPath()
+
+	if err != nil {
+		return err
+	}
+
+	// Clear out any socket left behind by a previous run that did not exit
+	// cleanly, but never take over one that is still being served.
+	if _, err := os.Stat(path); err == nil {
+		conn, err := net.Dial("unix", path)
+
+		if err == nil {
+			conn.Close()
+			return fmt.Errorf("control socket already in use: %s", path)
+		}
+
+		os.Remove(path)
+	}
+
+	listener, err := net.Listen("unix", path)
+
+	if err != nil {
+		return err
+	}
+
+	server.path = path
+	server.listener = listener
+	server.done = make(chan struct{})
+	server.conns = map[net.Conn]bool{}
+	server.subscribers = map[chan SaveState]bool{}
+
+	server.running.Add(1)
+	go server.accept()
+
+	return nil
+}
+
+// Close the socket and every open connection.
+func (server *ControlServer) Stop() error {
+
+	close(server.done)
+	err := server.listener.Close()
+
+	server.lock.Lock()
+	for conn := range server.conns {
+		conn.Close()
+	}
+	server.lock.Unlock()
+
+	server.running.Wait()
+	os.Remove(server.path)
+
+	return err
+}
+
+func (server *ControlServer) accept() {
+
+	defer server.running.Done()
+
+	for {
+		conn, err := server.listener.Accept()
+
+		if errors.Is(err, net.ErrClosed) {
+			return
+		} else if err != nil {
+			server.state.logger.Printf("Control socket accept failed: %s\n", err.Error())
+			continue
+		}
+
+		server.lock.Lock()
+		server.conns[conn] = true
+		server.lock.Unlock()
+
+		server.running.Add(1)
+		go server.serve(conn)
+	}
+}
+
+// Handle every request on a single connection, until it is closed or turned
+// into a subscription.
+func (server *ControlServer) serve(conn net.Conn) {
+
+	defer server.running.Done()
+	defer func() {
+		server.lock.Lock()
+		delete(server.conns, conn)
+		server.lock.Unlock()
+		conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	encoder := json.NewEncoder(conn)
+
+	for scanner.Scan() {
+		request := ControlRequest{}
+		err := json.Unmarshal(scanner.Bytes(), &request)
+
+		if err != nil {
+			encoder.Encode(ControlResponse{Error: fmt.Sprintf("invalid request: %s", err.Error())})
+			continue
+		}
+
+		if request.Command == "subscribe" {
+			server.subscribe(scanner, encoder)
+			return
+		}
+
+		if encoder.Encode(server.handle(request)) != nil {
+			return
+		}
+	}
+}
+
+// Run a single request against the tray.
+// Everything runs on the event loop, and changes are applied as events like
+// any other source, so the response can give the state they left behind.
+func (server *ControlServer) handle(request ControlRequest) ControlResponse {
+
+	state := server.state
+	response := ControlResponse{Error: "kb_ui is shutting down"}
+
+	switch request.Command {
+	case "get_state":
+		state.loop.Do(func() {
+			current := state.CurrentState()
+			response = ControlResponse{Ok: true, State: &current}
+		})
+
+		return response
+	case "list_layers":
+		state.loop.Do(func() {
+			layers := []ControlLayer{}
+
+			for _, keyboard := range state.keyboards {
+				for _, keybind := range keyboard.keybinds {
+					layers = append(layers, ControlLayer{Id: keybind.id, Name: keybind.name, Keyboard: keyboard.name})
+				}
+			}
+
+			response = ControlResponse{Ok: true, Layers: layers}
+		})
+
+		return response
+	case "set_layer":
+		state.loop.Do(func() {
+			keyboard := state.FindKeyboard(request.Keyboard)
+			if keyboard == nil {
+				response = ControlResponse{Error: fmt.Sprintf("unknown keyboard: %s", request.Keyboard)}
+				return
+			}
+
+			keybind := keyboard.FindLayer(-1, request.Layer)
+
+			if id, err := strconv.Atoi(request.Layer); keybind == nil && err == nil {
+				keybind = keyboard.FindLayer(id, "")
+			}
+
+			if keybind == nil {
+				response = ControlResponse{Error: fmt.Sprintf("unknown layer: %s", request.Layer)}
+				return
+			}
+
+			response = server.apply(LayerEvent{Kind: LayerChanged, LayerId: keybind.id, LayerName: keybind.name, Keyboard: keyboard.name})
+		})
+
+		return response
+	case "toggle_connect":
+		state.loop.Do(func() {
+			keyboard := state.FindKeyboard(request.Keyboard)
+			if keyboard == nil {
+				response = ControlResponse{Error: fmt.Sprintf("unknown keyboard: %s", request.Keyboard)}
+				return
+			}
+
+			response = server.apply(LayerEvent{Kind: ConnectToggled, Keyboard: keyboard.name})
+		})
+
+		return response
+	}
+
+	return ControlResponse{Error: fmt.Sprintf("unknown command: %s", request.Command)}
+}
+
+// Apply a change from the event loop, returning the state it left behind.
+func (server *ControlServer) apply(event LayerEvent) ControlResponse {
+
+	state := server.state
+
+	if state.quitting {
+		return ControlResponse{Error: "kb_ui is shutting down"}
+	}
+
+	state.HandleEvent(event)
+	current := state.CurrentState()
+
+	return ControlResponse{Ok: true, State: &current}
+}
+
+// Stream every state change to the connection, until the client hangs up.
+func (server *ControlServer) subscribe(scanner *bufio.Scanner, encoder *json.Encoder) {
+
+	changes := make(chan SaveState, controlSubscriberBuffer)
+
+	// Read the state and subscribe together, so no change can be missed.
+	running := server.state.loop.Do(func() {
+		changes <- server.state.CurrentState()
+
+		server.lock.Lock()
+		server.subscribers[changes] = true
+		server.lock.Unlock()
+	})
+
+	if !running {
+		return
+	}
+
+	defer func() {
+		server.lock.Lock()
+		delete(server.subscribers, changes)
+		server.lock.Unlock()
+	}()
+
+	// Nothing more is expected from the client, so just wait for it to go.
+	closed := make(chan struct{})
+	go func() {
+		for scanner.Scan() {
+		}
+		close(closed)
+	}()
+
+	for {
+		select {
+		case current := <-changes:
+			if encoder.Encode(ControlResponse{Ok: true, State: &current}) != nil {
+				return
+			}
+		case <-closed:
+			return
+		case <-server.done:
+			return
+		}
+	}
+}
+
+// Pass a state change on to every subscriber, dropping it for any that have
+// fallen too far behind rather than holding up the tray.
+func (server *ControlServer) broadcast(current SaveState) {
+
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	for changes := range server.subscribers {
+		select {
+		case changes <- current:
+		default:
+			server.state.logger.Println("Dropped state change for slow control subscriber")
+		}
+	}
+}
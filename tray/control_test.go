@@ -0,0 +1,147 @@
+package tray
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+// A plain client of the control socket, as a script would use it.
+type testClient struct {
+	t       *testing.T
+	conn    net.Conn
+	scanner *bufio.Scanner
+}
+
+func dialControl(t *testing.T) *testClient {
+
+	t.Helper()
+
+	path, err := ControlSocketPath()
+	if err != nil {
+		t.Fatalf("no control socket path: %s", err)
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("failed to connect to the control socket: %s", err)
+	}
+
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	return &testClient{t, conn, bufio.NewScanner(conn)}
+}
+
+func (client *testClient) send(line string) {
+
+	client.t.Helper()
+
+	if _, err := client.conn.Write([]byte(line + "\n")); err != nil {
+		client.t.Fatalf("failed to send %s: %s", line, err)
+	}
+}
+
+func (client *testClient) read() ControlResponse {
+
+	client.t.Helper()
+
+	if !client.scanner.Scan() {
+		client.t.Fatalf("no response: %v", client.scanner.Err())
+	}
+
+	response := ControlResponse{}
+	if err := json.Unmarshal(client.scanner.Bytes(), &response); err != nil {
+		client.t.Fatalf("invalid response %s: %s", client.scanner.Text(), err)
+	}
+
+	return response
+}
+
+func (client *testClient) request(line string) ControlResponse {
+
+	client.t.Helper()
+	client.send(line)
+
+	return client.read()
+}
+
+func TestControlServer(t *testing.T) {
+
+	state := newTestState(t, `{"layers": `+testLayers+`}`)
+
+	var server *ControlServer
+	var err error
+
+	state.loop.Do(func() {
+		server = NewControlServer(state)
+		err = server.Start()
+	})
+
+	if err != nil {
+		t.Fatalf("failed to start the control server: %s", err)
+	}
+
+	defer server.Stop()
+
+	client := dialControl(t)
+
+	response := client.request(`{"command": "get_state"}`)
+	if !response.Ok || response.State == nil || response.State.LayerName != "Base" {
+		t.Fatalf("get_state: expected Base, got %+v", response)
+	}
+
+	response = client.request(`{"command": "list_layers"}`)
+	names := []string{}
+	for _, layer := range response.Layers {
+		names = append(names, layer.Name)
+	}
+
+	if !response.Ok || len(names) != 3 || names[0] != "Base" || names[1] != "Nav" || names[2] != "Num" {
+		t.Fatalf("list_layers: expected Base, Nav and Num, got %+v", response)
+	}
+
+	subscriber := dialControl(t)
+	subscriber.send(`{"command": "subscribe"}`)
+
+	if current := subscriber.read(); current.State == nil || current.State.LayerName != "Base" {
+		t.Fatalf("subscribe: expected the current state first, got %+v", current)
+	}
+
+	// By name, then by id.
+	for _, step := range []struct {
+		request string
+		layer   string
+	}{
+		{`{"command": "set_layer", "layer": "Nav"}`, "Nav"},
+		{`{"command": "set_layer", "layer": "2"}`, "Num"},
+	} {
+		if response := client.request(step.request); !response.Ok {
+			t.Fatalf("%s failed: %s", step.request, response.Error)
+		} else if response.State == nil || response.State.LayerName != step.layer {
+			t.Fatalf("%s: expected the response to give %s, got %+v", step.request, step.layer, response)
+		}
+
+		if change := subscriber.read(); change.State == nil || change.State.LayerName != step.layer {
+			t.Fatalf("%s: expected the subscriber to see %s, got %+v", step.request, step.layer, change)
+		}
+	}
+
+	response = client.request(`{"command": "get_state"}`)
+	if response.State == nil || response.State.LayerName != "Num" {
+		t.Fatalf("get_state: expected Num after set_layer, got %+v", response)
+	}
+
+	for _, bad := range []string{
+		`{"command": "set_layer", "layer": "Missing"}`,
+		`{"command": "set_layer", "layer": "Nav", "keyboard": "Missing"}`,
+		`{"command": "dance"}`,
+		`not json`,
+	} {
+		if response := client.request(bad); response.Ok || response.Error == "" {
+			t.Errorf("%s: expected an error, got %+v", bad, response)
+		}
+	}
+}
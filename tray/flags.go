@@ -0,0 +1,208 @@
+package tray
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+
+	"github.com/getlantern/systray"
+	"golang.org/x/exp/slices"
+)
+
+// The predefined flag for the output connection, which is on while the
+// keyboard is connected to this host.
+const ConnectFlag = "connected"
+
+// A single on / off flag of a keyboard.
+type Flag struct {
+	name          string
+	config        FlagConfig
+	value         bool
+	icon          *[]byte
+	dark_icon     *[]byte
+	off_icon      *[]byte
+	dark_off_icon *[]byte
+	item          *systray.MenuItem
+}
+
+// Build the flags of a keyboard. Every flag starts off, apart from the
+// connected flag, since the keyboard is assumed to be connected.
+func MakeFlags(state *TrayState, config *KeyboardConfig) []*Flag {
+
+	flags := []*Flag{}
+
+	for _, flagConfig := range config.FlagConfigs() {
+		flag := &Flag{name: flagConfig.Name, config: flagConfig, value: flagConfig.Name == ConnectFlag}
+		flag.icon, flag.dark_icon = loadFlagIcons(state, flagConfig.Icon)
+		flag.off_icon, flag.dark_off_icon = loadFlagIcons(state, flagConfig.OffIcon)
+
+		flags = append(flags, flag)
+	}
+
+	return flags
+}
+
+// Load the light and dark variants of a flag icon. Only generated icons have
+// a dark variant, so icon files are used for both themes.
+func loadFlagIcons(state *TrayState, spec IconSpec) (*[]byte, *[]byte) {
+
+	icon := loadFlagIcon(state, spec, false)
+
+	dark_icon := icon
+	if spec.IsGenerated() {
+		dark_icon = loadFlagIcon(state, spec, true)
+	}
+
+	return &icon, &dark_icon
+}
+
+// Load a flag icon, which is optional, so nothing is shown if it can't be loaded.
+func loadFlagIcon(state *TrayState, spec IconSpec, dark bool) []byte {
+
+	if spec.IsEmpty() {
+		return nil
+	}
+
+	icon, err := LoadIcon(spec, dark)
+
+	if err != nil {
+		state.logger.Printf("Error parsing flag icon: %s\n", err.Error())
+		return nil
+	}
+
+	return icon
+}
+
+// Find a flag by name.
+func (keyboard *Keyboard) FindFlag(name string) *Flag {
+
+	i := slices.IndexFunc(keyboard.flags, func(flag *Flag) bool {
+		return flag.name == name
+	})
+
+	if i == -1 {
+		return nil
+	}
+
+	return keyboard.flags[i]
+}
+
+// Check if the output is connected to this host.
+func (keyboard *Keyboard) connected() bool {
+
+	flag := keyboard.FindFlag(ConnectFlag)
+
+	return flag == nil || flag.value
+}
+
+// Turn a flag on or off.
+func (keyboard *Keyboard) SetFlag(flag *Flag, value bool) {
+
+	if flag.value == value {
+		return
+	}
+
+	flag.value = value
+
+	state := keyboard.state
+	state.RefreshTray()
+	state.notifyChange()
+
+	if flag.name == ConnectFlag {
+		keyboard.notifyConnect()
+		keyboard.recordConnectStats()
+		keyboard.runConnectHooks()
+	}
+}
+
+// Get the icon to show for a flag in its current state, if any.
+func (flag *Flag) currentIcon(keyboard *Keyboard) []byte {
+
+	if flag.value {
+		return *keyboard.pickIcon(flag.icon, flag.dark_icon)
+	}
+
+	return *keyboard.pickIcon(flag.off_icon, flag.dark_off_icon)
+}
+
+// Get the names of every flag that is on, other than the connected flag which
+// is saved on its own.
+func (keyboard *Keyboard) flagValues() map[string]bool {
+
+	values := map[string]bool{}
+
+	for _, flag := range keyboard.flags {
+		if flag.name != ConnectFlag {
+			values[flag.name] = flag.value
+		}
+	}
+
+	if len(values) == 0 {
+		return nil
+	}
+
+	return values
+}
+
+// Make a binding for a chord that isn't for a layer, i.e. to change a flag.
+func MakeChordKeybinding(chord ChordConfig, name string) (Keybinding, error) {
+
+	mods, err := ParseModifiers(chord.Mods)
+	if err != nil {
+		return Keybinding{}, fmt.Errorf("failed to parse %s modifiers: %w", name, err)
+	}
+
+	key, err := ParseKey(chord.Key)
+	if err != nil {
+		return Keybinding{}, fmt.Errorf("failed to parse %s key: %w", name, err)
+	}
+
+	return Keybinding{nil, mods, key, -1, name, nil, nil, BehaviorTo, ""}, nil
+}
+
+// Draw flag icons over an icon, as badges in each corner, starting from the
+// bottom right.
+func overlayIcons(base []byte, overlays [][]byte) ([]byte, error) {
+
+	baseSource, err := decodeIcon(base)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := []iconSource{}
+
+	for _, overlay := range overlays {
+		source, err := decodeIcon(overlay)
+		if err != nil {
+			return nil, err
+		}
+
+		sources = append(sources, source)
+	}
+
+	return encodeIcon(func(size int) (image.Image, error) {
+
+		img, err := baseSource(size)
+		if err != nil {
+			return nil, err
+		}
+
+		combined := image.NewRGBA(image.Rect(0, 0, size, size))
+		draw.Draw(combined, combined.Bounds(), img, img.Bounds().Min, draw.Src)
+
+		badge := size / 2
+		corners := []image.Point{{size - badge, size - badge}, {0, size - badge}, {size - badge, 0}, {0, 0}}
+
+		for i, source := range sources {
+			img, err := source(badge)
+			if err != nil {
+				return nil, err
+			}
+
+			at := corners[i%len(corners)]
+			draw.Draw(combined, image.Rectangle{at, at.Add(image.Pt(badge, badge))}, img, img.Bounds().Min, draw.Over)
+		}
+
+		return combined, nil
+	})
+}
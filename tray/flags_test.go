@@ -0,0 +1,47 @@
+package tray
+
+import (
+	"bytes"
+	"testing"
+)
+
+// The theme is only known once the tray is running, so flag and output icons
+// have to follow it when it changes, not just use whatever it was at startup.
+func TestFlagIconsFollowTheme(t *testing.T) {
+
+	state := newTestState(t, `{
+		"layers": `+testLayers+`,
+		"ledDevice": "/dev/input/test-kbd",
+		"outputs": [{"name": "BT1", "icon": {"text": "1"}}]
+	}`)
+
+	state.loop.Do(func() {
+		keyboard := state.keyboards[0]
+		flag := keyboard.FindFlag(CapsLockFlag)
+		output := keyboard.FindOutput("BT1")
+
+		keyboard.SetFlag(flag, true)
+		keyboard.SetOutput(output)
+
+		if bytes.Equal(*flag.icon, *flag.dark_icon) || bytes.Equal(*output.icon, *output.dark_icon) {
+			t.Fatal("expected the generated icons to have a separate dark variant")
+		}
+
+		for _, scheme := range []ColorScheme{PreferDark, PreferLight, PreferDark} {
+			state.SetColorScheme(scheme)
+
+			wantFlag, wantOutput := *flag.icon, *output.icon
+			if scheme == PreferDark {
+				wantFlag, wantOutput = *flag.dark_icon, *output.dark_icon
+			}
+
+			if !bytes.Equal(flag.currentIcon(keyboard), wantFlag) {
+				t.Errorf("scheme %d: the flag icon did not follow the theme", scheme)
+			}
+
+			if icon, _ := keyboard.outputIcon(); !bytes.Equal(icon, wantOutput) {
+				t.Errorf("scheme %d: the output icon did not follow the theme", scheme)
+			}
+		}
+	})
+}
@@ -0,0 +1,137 @@
+package tray
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+const testGroups = `{
+	"layers": [
+		{"name": "Mac", "mods": "ctrl-shift", "key": "F1", "icon": {"text": "M"}, "group": "os"},
+		{"name": "Win", "mods": "ctrl-shift", "key": "F2", "icon": {"text": "W"}, "group": "os"},
+		{"name": "Typing", "mods": "ctrl-shift", "key": "F3", "icon": {"text": "T"}, "group": "mode"},
+		{"name": "Gaming", "mods": "ctrl-shift", "key": "F4", "icon": {"text": "G"}, "group": "mode"}
+	],
+	"combos": [
+		{"layers": ["Gaming"], "icon": {"text": "G!"}},
+		{"layers": ["Mac", "Gaming"], "icon": {"text": "MG"}}
+	]
+}`
+
+// Each group keeps its own layer, the title shows them all, and the icon is
+// the combo matching the most active layers.
+func TestLayerGroups(t *testing.T) {
+
+	state := newTestState(t, testGroups)
+
+	tests := []struct {
+		layer  string
+		title  string
+		combo  int
+		groups []GroupState
+	}{
+		{"", "Mac · Typing", -1, []GroupState{{"os", "Mac"}, {"mode", "Typing"}}},
+		{"Gaming", "Mac · Gaming", 1, []GroupState{{"os", "Mac"}, {"mode", "Gaming"}}},
+		{"Win", "Win · Gaming", 0, []GroupState{{"os", "Win"}, {"mode", "Gaming"}}},
+		{"Typing", "Win · Typing", -1, []GroupState{{"os", "Win"}, {"mode", "Typing"}}},
+		{"Mac", "Mac · Typing", -1, []GroupState{{"os", "Mac"}, {"mode", "Typing"}}},
+	}
+
+	for _, test := range tests {
+		var title string
+		var icon, want *[]byte
+		var current SaveState
+
+		state.loop.Do(func() {
+			keyboard := state.keyboards[0]
+
+			if test.layer != "" {
+				state.HandleEvent(LayerEvent{Kind: LayerChanged, LayerName: test.layer})
+			}
+
+			title = keyboard.title()
+			icon = keyboard.layerIcon()
+			current = keyboard.CurrentState()
+
+			if test.combo != -1 {
+				want = keyboard.combos[test.combo].icon
+			} else {
+				want = keyboard.FindLayer(keyboard.layer_id, "").icon
+			}
+		})
+
+		if title != test.title {
+			t.Errorf("%s: expected the title %q, got %q", test.layer, test.title, title)
+		}
+
+		if icon != want {
+			t.Errorf("%s: expected the icon of combo %d", test.layer, test.combo)
+		}
+
+		if !slices.Equal(current.Groups, test.groups) {
+			t.Errorf("%s: expected the groups %+v, got %+v", test.layer, test.groups, current.Groups)
+		}
+	}
+}
+
+// Every group is saved, and put back on the next run.
+func TestLayerGroupsAreSaved(t *testing.T) {
+
+	state := newTestState(t, testGroups)
+
+	state.loop.Do(func() {
+		state.HandleEvent(LayerEvent{Kind: LayerChanged, LayerName: "Win"})
+		state.HandleEvent(LayerEvent{Kind: LayerChanged, LayerName: "Gaming"})
+		state.SaveCurrentState()
+
+		// Move away again, so only the load can put them back.
+		state.HandleEvent(LayerEvent{Kind: LayerChanged, LayerName: "Mac"})
+		state.HandleEvent(LayerEvent{Kind: LayerChanged, LayerName: "Typing"})
+	})
+
+	path, _ := StatePath()
+	data, err := os.ReadFile(path)
+	saved := SaveState{}
+
+	if err != nil || json.Unmarshal(data, &saved) != nil {
+		t.Fatalf("failed to read the state file: %s", err)
+	}
+
+	want := []GroupState{{"os", "Win"}, {"mode", "Gaming"}}
+	if !slices.Equal(saved.Groups, want) {
+		t.Errorf("expected to save the groups %+v, got %+v", want, saved.Groups)
+	}
+
+	var title string
+
+	state.loop.Do(func() {
+		state.LoadPreviousState()
+		title = state.keyboards[0].title()
+	})
+
+	if title != "Win · Gaming" {
+		t.Errorf("expected to restore both groups, got %q", title)
+	}
+}
+
+// State files from before the layer stack only have the groups.
+func TestLayerGroupsRestoreWithoutStack(t *testing.T) {
+
+	state := newTestState(t, testGroups)
+
+	writeStateFile(t, `{"id": 3, "name": "Gaming", "is_connected": true, "groups": [{"group": "os", "layer": "Win"}, {"group": "mode", "layer": "Gaming"}]}`)
+
+	var title string
+
+	state.loop.Do(func() {
+		state.LoadPreviousState()
+		title = state.keyboards[0].title()
+	})
+
+	if title != "Win · Gaming" {
+		t.Errorf("expected to restore both groups, got %q", title)
+	}
+}
@@ -0,0 +1,102 @@
+package tray
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/adrg/xdg"
+)
+
+// Point every XDG directory at a temporary directory, so tests never touch the
+// real config, state or log files.
+func useTempDirs(t *testing.T) {
+
+	t.Helper()
+	t.Cleanup(xdg.Reload)
+
+	dir := t.TempDir()
+
+	for _, name := range []string{"XDG_CONFIG_HOME", "XDG_DATA_HOME", "XDG_STATE_HOME", "XDG_CACHE_HOME", "XDG_RUNTIME_DIR"} {
+		t.Setenv(name, filepath.Join(dir, name))
+	}
+
+	xdg.Reload()
+}
+
+// Build a tray state for the given config, without starting any layer
+// sources, so nothing is registered with the desktop.
+func newTestState(t *testing.T, config string) *TrayState {
+
+	t.Helper()
+	useTempDirs(t)
+
+	cfg, err := ParseConfig([]byte(config))
+	if err != nil {
+		t.Fatalf("failed to parse config: %s", err)
+	}
+
+	state := GetInitialState()
+	t.Cleanup(state.loop.Stop)
+
+	keyboards, err := MakeKeyboards(&state, &cfg)
+	if err != nil {
+		t.Fatalf("failed to make keyboards: %s", err)
+	}
+
+	state.loop.Do(func() {
+		SetupInitialTrayState(&state)
+		state.ApplyConfig(&cfg, keyboards)
+	})
+
+	return &state
+}
+
+// An event kind HandleEvent ignores, used to tell when a source is drained.
+const syncEvent LayerEventKind = -1
+
+// A layer source that sends a fixed list of events, then closes.
+type fakeSource struct {
+	events  chan LayerEvent
+	send    []LayerEvent
+	drained chan struct{}
+}
+
+func newFakeSource(events ...LayerEvent) *fakeSource {
+	return &fakeSource{send: events, drained: make(chan struct{})}
+}
+
+// Send every event, then a sync event. The channel is unbuffered, and each
+// event is posted to the loop before the next is read, so once the sync event
+// is taken every real event is already queued on the loop.
+func (source *fakeSource) Start() error {
+
+	source.events = make(chan LayerEvent)
+
+	go func() {
+		defer close(source.drained)
+		defer close(source.events)
+
+		for _, event := range source.send {
+			source.events <- event
+		}
+
+		source.events <- LayerEvent{Kind: syncEvent}
+	}()
+
+	return nil
+}
+
+func (source *fakeSource) Stop() error {
+	return nil
+}
+
+func (source *fakeSource) Events() <-chan LayerEvent {
+	return source.events
+}
+
+// The layers every test config starts from.
+const testLayers = `[
+	{"name": "Base", "mods": "ctrl-shift", "key": "F1", "icon": {"text": "B"}},
+	{"name": "Nav", "mods": "ctrl-shift", "key": "F2", "icon": {"text": "N"}},
+	{"name": "Num", "mods": "ctrl-shift", "key": "F3", "icon": {"text": "1"}}
+]`
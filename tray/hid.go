@@ -0,0 +1,119 @@
+package tray
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// Layer reports sent by the keyboard over its raw HID interface.
+//
+// Every report starts with a fixed header:
+//
+//	byte 0: report type, always HidLayerReport
+//	byte 1: the active layer, as an index into the configured layers
+//	byte 2: flags, bit 0 is set while the output is connected to this host
+//
+// Anything after the header is padding, since raw HID reports are fixed size
+// (usually 32 bytes for ZMK / QMK).
+const (
+	HidLayerReport   byte = 0x4c
+	HidConnectedFlag byte = 0x01
+
+	hidHeaderSize = 3
+	hidMaxReport  = 64
+)
+
+type HidReport struct {
+	LayerId     int
+	IsConnected bool
+}
+
+// Decode a single raw HID report into the layer it describes.
+func DecodeHidReport(report []byte) (HidReport, error) {
+
+	if len(report) < hidHeaderSize {
+		return HidReport{}, fmt.Errorf("hid report too short: %d bytes", len(report))
+	}
+
+	if report[0] != HidLayerReport {
+		return HidReport{}, fmt.Errorf("unknown hid report type: 0x%02x", report[0])
+	}
+
+	layerId := int(report[1])
+	isConnected := report[2]&HidConnectedFlag != 0
+
+	return HidReport{layerId, isConnected}, nil
+}
+
+// Read reports from the device until it is closed, passing each valid one to
+// the handler. A hidraw device returns exactly one report per read, so any
+// reader that does the same (a pipe, a fake device file) can be used here.
+func ReadHidReports(reader io.Reader, handler func(HidReport)) error {
+
+	buffer := make([]byte, hidMaxReport)
+
+	for {
+		n, err := reader.Read(buffer)
+
+		if n > 0 {
+			report, decodeErr := DecodeHidReport(buffer[:n])
+
+			if decodeErr == nil {
+				handler(report)
+			}
+		}
+
+		if errors.Is(err, io.EOF) || errors.Is(err, os.ErrClosed) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// A raw HID device as a layer source.
+type HidSource struct {
+	path     string
+	keyboard string
+	device   io.Closer
+	events   chan LayerEvent
+}
+
+func NewHidSource(path string, keyboard string) *HidSource {
+	return &HidSource{path: path, keyboard: keyboard}
+}
+
+// Open the raw HID device, and report every layer change the keyboard sends.
+func (source *HidSource) Start() error {
+
+	device, err := openHidDevice(source.path)
+
+	if err != nil {
+		return err
+	}
+
+	source.device = device
+	source.events = make(chan LayerEvent)
+
+	go func() {
+		defer close(source.events)
+
+		ReadHidReports(device, func(report HidReport) {
+			source.events <- LayerEvent{Kind: LayerChanged, LayerId: report.LayerId, Keyboard: source.keyboard}
+			source.events <- LayerEvent{Kind: ConnectChanged, IsConnected: report.IsConnected, Keyboard: source.keyboard}
+		})
+	}()
+
+	return nil
+}
+
+// Close the device, which ends the read loop and closes the events channel.
+func (source *HidSource) Stop() error {
+	return source.device.Close()
+}
+
+func (source *HidSource) Events() <-chan LayerEvent {
+	return source.events
+}
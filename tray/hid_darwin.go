@@ -0,0 +1,13 @@
+//go:build darwin
+
+package tray
+
+import (
+	"errors"
+	"os"
+)
+
+// Raw HID devices are only read through /dev/hidraw for now.
+func openHidDevice(path string) (*os.File, error) {
+	return nil, errors.New("raw hid devices are only supported on linux")
+}
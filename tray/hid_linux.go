@@ -0,0 +1,18 @@
+//go:build linux
+
+package tray
+
+import (
+	"errors"
+	"os"
+)
+
+// Open a raw HID device, i.e. /dev/hidrawN.
+func openHidDevice(path string) (*os.File, error) {
+
+	if path == "" {
+		return nil, errors.New("no hid device configured")
+	}
+
+	return os.Open(path)
+}
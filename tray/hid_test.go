@@ -0,0 +1,135 @@
+package tray
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDecodeHidReport(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		report []byte
+		want   HidReport
+		fails  bool
+	}{
+		{"empty", []byte{}, HidReport{}, true},
+		{"short", []byte{HidLayerReport, 2}, HidReport{}, true},
+		{"unknown type", []byte{0x00, 2, HidConnectedFlag}, HidReport{}, true},
+		{"connected", []byte{HidLayerReport, 2, HidConnectedFlag}, HidReport{2, true}, false},
+		{"disconnected", []byte{HidLayerReport, 1, 0x00}, HidReport{1, false}, false},
+		{"other flags", []byte{HidLayerReport, 0, 0xfe}, HidReport{0, false}, false},
+		{"padded", append([]byte{HidLayerReport, 5, HidConnectedFlag}, make([]byte, 29)...), HidReport{5, true}, false},
+	}
+
+	for _, test := range tests {
+		got, err := DecodeHidReport(test.report)
+
+		if test.fails && err == nil {
+			t.Errorf("%s: expected an error, got %+v", test.name, got)
+		} else if !test.fails && err != nil {
+			t.Errorf("%s: failed: %s", test.name, err)
+		} else if got != test.want {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+// Only the valid reports should be passed on, in the order they were sent.
+var hidTestReports = [][]byte{
+	{HidLayerReport, 1, HidConnectedFlag},
+	{HidLayerReport},
+	{0x42, 3, HidConnectedFlag},
+	{HidLayerReport, 2, 0x00},
+	append([]byte{HidLayerReport, 0, HidConnectedFlag}, make([]byte, 29)...),
+}
+
+var hidTestWant = []HidReport{{1, true}, {2, false}, {0, true}}
+
+func checkHidReports(t *testing.T, got []HidReport) {
+
+	t.Helper()
+
+	if len(got) != len(hidTestWant) {
+		t.Fatalf("got %d reports %+v, want %+v", len(got), got, hidTestWant)
+	}
+
+	for i := range got {
+		if got[i] != hidTestWant[i] {
+			t.Errorf("report %d: got %+v, want %+v", i, got[i], hidTestWant[i])
+		}
+	}
+}
+
+func TestReadHidReportsFromPipe(t *testing.T) {
+
+	reader, writer := io.Pipe()
+
+	go func() {
+		for _, report := range hidTestReports {
+			writer.Write(report)
+		}
+
+		writer.Close()
+	}()
+
+	got := []HidReport{}
+	err := ReadHidReports(reader, func(report HidReport) {
+		got = append(got, report)
+	})
+
+	if err != nil {
+		t.Fatalf("read failed: %s", err)
+	}
+
+	checkHidReports(t, got)
+}
+
+// A closed device ends the read without an error, as when the source stops.
+func TestReadHidReportsClosed(t *testing.T) {
+
+	reader, writer := io.Pipe()
+	writer.CloseWithError(os.ErrClosed)
+
+	if err := ReadHidReports(reader, func(HidReport) {}); err != nil {
+		t.Fatalf("expected a closed device to end the read cleanly, got %s", err)
+	}
+
+	reader, writer = io.Pipe()
+	writer.CloseWithError(io.ErrUnexpectedEOF)
+
+	if err := ReadHidReports(reader, func(HidReport) {}); err == nil {
+		t.Fatal("expected a failed read to be returned")
+	}
+}
+
+// A file returns as much as fits in each read, so one report per file.
+func TestReadHidReportsFromFile(t *testing.T) {
+
+	got := []HidReport{}
+
+	for i, report := range hidTestReports {
+		path := filepath.Join(t.TempDir(), "hidraw")
+		if err := os.WriteFile(path, report, 0644); err != nil {
+			t.Fatalf("failed to write report %d: %s", i, err)
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("failed to open report %d: %s", i, err)
+		}
+
+		err = ReadHidReports(file, func(report HidReport) {
+			got = append(got, report)
+		})
+		file.Close()
+
+		if err != nil {
+			t.Fatalf("read of report %d failed: %s", i, err)
+		}
+	}
+
+	checkHidReports(t, got)
+}
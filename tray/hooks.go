@@ -0,0 +1,111 @@
+package tray
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// How long a single hook command can run before it is killed.
+var hookTimeout = 10 * time.Second
+
+// How long to wait for a killed hook to close its output, since anything it
+// started may still hold it open.
+const hookWaitDelay = time.Second
+
+// Run the exit hooks of the previous layer, then the enter hooks of the new one.
+func (keyboard *Keyboard) runLayerHooks(previous *Keybinding, current *Keybinding) {
+
+	commands := []string{}
+
+	if config := keyboard.layerConfig(previous); config != nil {
+		commands = append(commands, config.OnExit...)
+	}
+
+	if config := keyboard.layerConfig(current); config != nil {
+		commands = append(commands, config.OnEnter...)
+	}
+
+	keyboard.runHooks(commands, previous, current)
+}
+
+// Run the connect or disconnect hooks, for the new connection state.
+func (keyboard *Keyboard) runConnectHooks() {
+
+	commands := keyboard.config.OnDisconnect
+	if keyboard.connected() {
+		commands = keyboard.config.OnConnect
+	}
+
+	current := keyboard.FindLayer(keyboard.layer_id, "")
+	keyboard.runHooks(commands, current, current)
+}
+
+// Run each command in order, in the background so the tray never waits on them,
+// after the hooks of any earlier change. The commands are told about the change
+// through their environment.
+func (keyboard *Keyboard) runHooks(commands []string, previous *Keybinding, current *Keybinding) {
+
+	if len(commands) == 0 {
+		return
+	}
+
+	previousName := ""
+	if previous != nil {
+		previousName = previous.name
+	}
+
+	currentName, group := "", ""
+	if current != nil {
+		currentName, group = current.name, current.group
+	}
+
+	state := keyboard.state
+	env := append(os.Environ(),
+		fmt.Sprintf("KB_UI_KEYBOARD=%s", keyboard.name),
+		fmt.Sprintf("KB_UI_LAYER=%s", currentName),
+		fmt.Sprintf("KB_UI_GROUP=%s", group),
+		fmt.Sprintf("KB_UI_PREV_LAYER=%s", previousName),
+		fmt.Sprintf("KB_UI_CONNECTED=%t", keyboard.connected()),
+	)
+
+	// The queue runs off the loop, so it gets its own copy of the timeout.
+	timeout := hookTimeout
+
+	state.hooks.add(func() {
+		for _, command := range commands {
+			output, err := runHook(command, env, timeout)
+
+			if len(output) != 0 {
+				state.logger.Printf("Hook %q output:\n%s\n", command, strings.TrimRight(string(output), "\n"))
+			}
+
+			if err != nil {
+				state.logger.Printf("Hook %q failed: %s\n", command, err.Error())
+			}
+		}
+	})
+}
+
+// Run a single hook command through the shell, returning everything it output.
+func runHook(command string, env []string, timeout time.Duration) ([]byte, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	shell := hookShell()
+	cmd := exec.CommandContext(ctx, shell[0], append(shell[1:], command)...)
+	cmd.Env = env
+	cmd.WaitDelay = hookWaitDelay
+
+	output, err := cmd.CombinedOutput()
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return output, fmt.Errorf("timed out after %s", timeout)
+	}
+
+	return output, err
+}
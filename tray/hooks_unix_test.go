@@ -0,0 +1,117 @@
+//go:build !windows
+
+package tray
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// A hook command that appends its label and environment to a file, as JSON.
+func hookCommand(label string, file string) string {
+
+	command := fmt.Sprintf(`echo "%s|$KB_UI_KEYBOARD|$KB_UI_LAYER|$KB_UI_PREV_LAYER|$KB_UI_GROUP|$KB_UI_CONNECTED" >> '%s'`, label, file)
+	quoted, _ := json.Marshal(command)
+
+	return string(quoted)
+}
+
+// Wait for the hooks to write the given number of lines to a file.
+func waitForHookLines(t *testing.T, file string, count int) []string {
+
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		output, _ := os.ReadFile(file)
+		lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+
+		if len(output) != 0 && len(lines) >= count {
+			return lines
+		} else if time.Now().After(deadline) {
+			t.Fatalf("expected %d hook lines, got %q", count, output)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHooksRunInOrderWithEnvironment(t *testing.T) {
+
+	file := filepath.Join(t.TempDir(), "hooks")
+
+	state := newTestState(t, fmt.Sprintf(`{"keyboards": [{
+		"name": "Sofle",
+		"layers": [
+			{"name": "Base", "mods": "ctrl-shift", "key": "F1", "icon": {"text": "B"}, "group": "main", "on_exit": [%s]},
+			{"name": "Nav", "mods": "ctrl-shift", "key": "F2", "icon": {"text": "N"}, "group": "main", "on_enter": [%s]}
+		],
+		"on_connect": [%s],
+		"on_disconnect": [%s]
+	}]}`, hookCommand("exit", file), hookCommand("enter", file), hookCommand("connect", file), hookCommand("disconnect", file)))
+
+	state.loop.Do(func() {
+		state.HandleEvent(LayerEvent{Kind: LayerChanged, Keyboard: "Sofle", LayerName: "Nav"})
+		state.HandleEvent(LayerEvent{Kind: ConnectChanged, Keyboard: "Sofle", IsConnected: false})
+		state.HandleEvent(LayerEvent{Kind: ConnectChanged, Keyboard: "Sofle", IsConnected: true})
+	})
+
+	want := []string{
+		"exit|Sofle|Nav|Base|main|true",
+		"enter|Sofle|Nav|Base|main|true",
+		"disconnect|Sofle|Nav|Nav|main|false",
+		"connect|Sofle|Nav|Nav|main|true",
+	}
+
+	if lines := waitForHookLines(t, file, len(want)); !slices.Equal(lines, want) {
+		t.Errorf("expected hooks to run as %q, got %q", want, lines)
+	}
+}
+
+func TestHookTimeoutDoesNotBlock(t *testing.T) {
+
+	timeout := hookTimeout
+	hookTimeout = 200 * time.Millisecond
+	t.Cleanup(func() { hookTimeout = timeout })
+
+	file := filepath.Join(t.TempDir(), "hooks")
+
+	state := newTestState(t, fmt.Sprintf(`{"layers": [
+		{"name": "Base", "mods": "ctrl-shift", "key": "F1", "icon": {"text": "B"}},
+		{"name": "Nav", "mods": "ctrl-shift", "key": "F2", "icon": {"text": "N"}, "on_enter": ["sleep 30", %s]}
+	]}`, hookCommand("enter", file)))
+
+	start := time.Now()
+
+	state.loop.Do(func() {
+		state.HandleEvent(LayerEvent{Kind: LayerChanged, LayerName: "Nav"})
+	})
+
+	// The loop carries on while the hook hangs.
+	var layer string
+	state.loop.Do(func() { layer = state.CurrentState().LayerName })
+
+	if elapsed := time.Since(start); elapsed > hookTimeout {
+		t.Errorf("the loop waited %s on a hung hook", elapsed)
+	} else if layer != "Nav" {
+		t.Errorf("expected to be on Nav, got %s", layer)
+	}
+
+	// The hung hook is killed, and the next one still runs.
+	lines := waitForHookLines(t, file, 1)
+
+	if elapsed := time.Since(start); elapsed < hookTimeout || elapsed > 5*time.Second {
+		t.Errorf("expected the hung hook to be killed after %s, took %s", hookTimeout, elapsed)
+	}
+
+	if want := "enter||Nav|Base||true"; lines[0] != want {
+		t.Errorf("expected %q, got %q", want, lines[0])
+	}
+}
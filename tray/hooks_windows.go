@@ -0,0 +1,8 @@
+//go:build windows
+
+package tray
+
+// The shell used to run hook commands.
+func hookShell() []string {
+	return []string{"cmd.exe", "/C"}
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+
+package tray
+
+import (
+	"bytes"
+	"image/png"
+)
+
+// The tray scales the icon down to fit the panel, so a single large PNG is
+// enough to stay sharp on HiDPI screens.
+const trayIconSize = 128
+
+// Encode an icon in the format the tray expects, which is PNG here.
+func encodeIcon(source iconSource) ([]byte, error) {
+
+	img, err := source(trayIconSize)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.Buffer{}
+	err = png.Encode(&buf, img)
+
+	return buf.Bytes(), err
+}
@@ -0,0 +1,65 @@
+//go:build windows
+
+package tray
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image/png"
+)
+
+// Windows picks the closest size for the display scaling, from 100% up to 250%
+// in the tray, and larger sizes elsewhere.
+var trayIconSizes = []int{16, 20, 24, 32, 40, 48, 64, 256}
+
+type icoEntry struct {
+	Width   uint8
+	Height  uint8
+	Colors  uint8
+	Padding uint8
+	Planes  uint16
+	Bits    uint16
+	Length  uint32
+	Offset  uint32
+}
+
+// Encode an icon in the format the tray expects, which is ICO here, with an
+// image for each display scale. Windows has read PNG images stored inside ICO
+// files since Vista, so each image is just a PNG.
+func encodeIcon(source iconSource) ([]byte, error) {
+
+	images := [][]byte{}
+
+	for _, size := range trayIconSizes {
+		img, err := source(size)
+		if err != nil {
+			return nil, err
+		}
+
+		data := bytes.Buffer{}
+		if err := png.Encode(&data, img); err != nil {
+			return nil, err
+		}
+
+		images = append(images, data.Bytes())
+	}
+
+	buf := bytes.Buffer{}
+	binary.Write(&buf, binary.LittleEndian, []uint16{0, 1, uint16(len(images))})
+
+	offset := 6 + 16*len(images)
+
+	for i, data := range images {
+		// A size of 0 means 256 pixels.
+		size := uint8(trayIconSizes[i] % 256)
+
+		binary.Write(&buf, binary.LittleEndian, icoEntry{size, size, 0, 0, 1, 32, uint32(len(data)), uint32(offset)})
+		offset += len(data)
+	}
+
+	for _, data := range images {
+		buf.Write(data)
+	}
+
+	return buf.Bytes(), nil
+}
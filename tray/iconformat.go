@@ -0,0 +1,288 @@
+package tray
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+	"golang.org/x/image/draw"
+)
+
+// The image formats icons can be loaded from.
+type IconFormat int
+
+const (
+	IconUnknown IconFormat = iota
+	IconIco
+	IconPng
+	IconSvg
+)
+
+var iconFormatNames = []string{"unknown", "ico", "png", "svg"}
+
+func (format IconFormat) String() string {
+	return iconFormatNames[format]
+}
+
+var (
+	icoMagic = []byte{0, 0, 1, 0}
+	pngMagic = []byte("\x89PNG\r\n\x1a\n")
+)
+
+// Something that can draw an icon at any size, so each size the tray needs
+// can be drawn as sharp as possible.
+type iconSource func(size int) (image.Image, error)
+
+// Work out the format of an icon from its contents, rather than trusting the
+// file extension.
+func DetectIconFormat(data []byte) IconFormat {
+
+	if bytes.HasPrefix(data, icoMagic) {
+		return IconIco
+	} else if bytes.HasPrefix(data, pngMagic) {
+		return IconPng
+	}
+
+	// SVGs can start with an XML declaration, comments or a doctype, so just
+	// look for the svg element near the start.
+	head := data[:min(len(data), 4096)]
+	if bytes.Contains(head, []byte("<svg")) {
+		return IconSvg
+	}
+
+	return IconUnknown
+}
+
+// Convert an icon in any supported format into the format the tray expects.
+func ConvertIcon(data []byte) ([]byte, error) {
+
+	source, err := decodeIcon(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return encodeIcon(source)
+}
+
+// Decode an icon, ready to be drawn at any size.
+func decodeIcon(data []byte) (iconSource, error) {
+
+	switch DetectIconFormat(data) {
+	case IconIco:
+		images, err := decodeIco(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ICO image: %w", err)
+		}
+
+		return scaledSource(images), nil
+	case IconPng:
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("invalid PNG image: %w", err)
+		}
+
+		return scaledSource([]image.Image{img}), nil
+	case IconSvg:
+		source, err := decodeSvg(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SVG image: %w", err)
+		}
+
+		return source, nil
+	}
+
+	return nil, errors.New("unrecognised image format, icons should be ICO, PNG or SVG")
+}
+
+// Draw an icon from the closest of the given images, scaling it to fit.
+func scaledSource(images []image.Image) iconSource {
+
+	return func(size int) (image.Image, error) {
+
+		// Prefer scaling down from the smallest image that is big enough.
+		best := images[0]
+		for _, img := range images[1:] {
+			bestSize := best.Bounds().Dx()
+			imgSize := img.Bounds().Dx()
+
+			if bestSize < size && imgSize > bestSize || imgSize >= size && imgSize < bestSize {
+				best = img
+			}
+		}
+
+		return fitImage(best, size), nil
+	}
+}
+
+// Scale an image to fill a square of the given size, keeping its aspect ratio.
+func fitImage(img image.Image, size int) image.Image {
+
+	bounds := img.Bounds()
+	if bounds.Dx() == size && bounds.Dy() == size {
+		return img
+	}
+
+	width, height := size, size
+	if bounds.Dx() > bounds.Dy() {
+		height = max(1, size*bounds.Dy()/bounds.Dx())
+	} else if bounds.Dy() > bounds.Dx() {
+		width = max(1, size*bounds.Dx()/bounds.Dy())
+	}
+
+	target := image.Rect((size-width)/2, (size-height)/2, (size-width)/2+width, (size-height)/2+height)
+	scaled := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.CatmullRom.Scale(scaled, target, img, bounds, draw.Over, nil)
+
+	return scaled
+}
+
+// Decode every image in an ICO file. Each image is either a PNG, or a
+// bottom-up bitmap followed by a 1-bit transparency mask.
+func decodeIco(data []byte) ([]image.Image, error) {
+
+	if len(data) < 6 {
+		return nil, errors.New("file is too short")
+	}
+
+	count := int(binary.LittleEndian.Uint16(data[4:]))
+	if count == 0 {
+		return nil, errors.New("no images in file")
+	} else if len(data) < 6+count*16 {
+		return nil, errors.New("file is too short for its directory")
+	}
+
+	images := []image.Image{}
+
+	for i := 0; i < count; i++ {
+		entry := data[6+i*16:]
+		length := int(binary.LittleEndian.Uint32(entry[8:]))
+		offset := int(binary.LittleEndian.Uint32(entry[12:]))
+
+		if offset < 0 || length < 0 || offset+length > len(data) || offset+length < offset {
+			return nil, fmt.Errorf("image %d is outside the file", i)
+		}
+
+		imgData := data[offset : offset+length]
+
+		var img image.Image
+		var err error
+
+		if bytes.HasPrefix(imgData, pngMagic) {
+			img, err = png.Decode(bytes.NewReader(imgData))
+		} else {
+			img, err = decodeIcoBitmap(imgData)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("image %d: %w", i, err)
+		}
+
+		images = append(images, img)
+	}
+
+	return images, nil
+}
+
+// Decode a 24 or 32-bit bitmap from an ICO file.
+func decodeIcoBitmap(data []byte) (image.Image, error) {
+
+	if len(data) < 40 || binary.LittleEndian.Uint32(data) < 40 {
+		return nil, errors.New("invalid bitmap header")
+	}
+
+	headerSize := int(binary.LittleEndian.Uint32(data))
+	width := int(int32(binary.LittleEndian.Uint32(data[4:])))
+	// The height covers both the image and the mask.
+	height := int(int32(binary.LittleEndian.Uint32(data[8:]))) / 2
+	bits := int(binary.LittleEndian.Uint16(data[14:]))
+
+	if width <= 0 || height <= 0 || width > 1024 || height > 1024 {
+		return nil, fmt.Errorf("invalid bitmap size %dx%d", width, height)
+	} else if bits != 24 && bits != 32 {
+		return nil, fmt.Errorf("unsupported %d-bit bitmap, only 24 and 32-bit are supported", bits)
+	}
+
+	stride := (width*bits + 31) / 32 * 4
+	maskStride := (width + 31) / 32 * 4
+	pixels := data[min(headerSize, len(data)):]
+
+	if len(pixels) < stride*height {
+		return nil, errors.New("bitmap data is truncated")
+	}
+
+	// Older icons only have the mask for transparency, without an alpha channel.
+	mask := pixels[stride*height:]
+	hasMask := len(mask) >= maskStride*height
+	hasAlpha := false
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		row := pixels[(height-1-y)*stride:]
+
+		for x := 0; x < width; x++ {
+			pixel := row[x*bits/8:]
+			alpha := uint8(0xff)
+
+			if bits == 32 {
+				alpha = pixel[3]
+				hasAlpha = hasAlpha || alpha != 0
+			}
+
+			img.SetNRGBA(x, y, color.NRGBA{pixel[2], pixel[1], pixel[0], alpha})
+		}
+	}
+
+	if !hasAlpha && hasMask {
+		for y := 0; y < height; y++ {
+			row := mask[(height-1-y)*maskStride:]
+
+			for x := 0; x < width; x++ {
+				c := img.NRGBAAt(x, y)
+				c.A = 0xff
+
+				if row[x/8]&(0x80>>(x%8)) != 0 {
+					c.A = 0
+				}
+
+				img.SetNRGBA(x, y, c)
+			}
+		}
+	}
+
+	return img, nil
+}
+
+// Parse an SVG, which is rasterised at each size it is drawn at.
+func decodeSvg(data []byte) (iconSource, error) {
+
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(data), oksvg.IgnoreErrorMode)
+	if err != nil {
+		return nil, err
+	}
+
+	if icon.ViewBox.W <= 0 || icon.ViewBox.H <= 0 {
+		return nil, errors.New("no width, height or viewBox given")
+	}
+
+	return func(size int) (image.Image, error) {
+
+		// Keep the aspect ratio, centring the image in the square icon.
+		scale := float64(size) / max(icon.ViewBox.W, icon.ViewBox.H)
+		width := icon.ViewBox.W * scale
+		height := icon.ViewBox.H * scale
+		icon.SetTarget((float64(size)-width)/2, (float64(size)-height)/2, width, height)
+
+		img := image.NewRGBA(image.Rect(0, 0, size, size))
+		scanner := rasterx.NewScannerGV(size, size, img, img.Bounds())
+		icon.Draw(rasterx.NewDasher(size, size, scanner), 1)
+
+		return img, nil
+	}, nil
+}
@@ -0,0 +1,233 @@
+package tray
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/CrossR/kb_ui/tray/icons"
+)
+
+const testSvg = `<?xml version="1.0" encoding="UTF-8"?>
+<!-- A red dot -->
+<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10">
+	<circle cx="5" cy="5" r="4" fill="#ff0000"/>
+</svg>`
+
+// Encode a PNG of the given size, filled with a single colour.
+func testPng(t *testing.T, width int, height int, fill color.RGBA) []byte {
+
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetRGBA(x, y, fill)
+		}
+	}
+
+	buf := bytes.Buffer{}
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("failed to encode PNG: %s", err)
+	}
+
+	return buf.Bytes()
+}
+
+// Build an ICO holding a single 2x2 24-bit bitmap, of a blue top row and a
+// green bottom row, with the top left pixel masked out.
+func testIco() []byte {
+
+	const width, height = 2, 2
+
+	bitmap := bytes.Buffer{}
+	binary.Write(&bitmap, binary.LittleEndian, []uint32{40, width, height * 2})
+	binary.Write(&bitmap, binary.LittleEndian, []uint16{1, 24})
+	bitmap.Write(make([]byte, 24))
+
+	// Rows are bottom up, in BGR, padded to four bytes.
+	bitmap.Write([]byte{0, 0xff, 0, 0, 0xff, 0, 0, 0})
+	bitmap.Write([]byte{0xff, 0, 0, 0xff, 0, 0, 0, 0})
+
+	// The mask rows are bottom up too, a bit per pixel.
+	bitmap.Write([]byte{0, 0, 0, 0})
+	bitmap.Write([]byte{0x80, 0, 0, 0})
+
+	buf := bytes.Buffer{}
+	binary.Write(&buf, binary.LittleEndian, []uint16{0, 1, 1})
+	binary.Write(&buf, binary.LittleEndian, []uint8{width, height, 0, 0})
+	binary.Write(&buf, binary.LittleEndian, []uint16{1, 24})
+	binary.Write(&buf, binary.LittleEndian, []uint32{uint32(bitmap.Len()), 22})
+	buf.Write(bitmap.Bytes())
+
+	return buf.Bytes()
+}
+
+func TestDetectIconFormat(t *testing.T) {
+
+	tests := []struct {
+		name string
+		data []byte
+		want IconFormat
+	}{
+		{"builtin", icons.KB_Light_Data, IconIco},
+		{"bitmap ico", testIco(), IconIco},
+		{"png", testPng(t, 4, 4, color.RGBA{}), IconPng},
+		{"svg", []byte(testSvg), IconSvg},
+		{"bare svg", []byte(`<svg viewBox="0 0 1 1"/>`), IconSvg},
+		{"text", []byte("not an icon"), IconUnknown},
+		{"empty", nil, IconUnknown},
+	}
+
+	for _, test := range tests {
+		if got := DetectIconFormat(test.data); got != test.want {
+			t.Errorf("%s: expected %s, got %s", test.name, test.want, got)
+		}
+	}
+}
+
+func TestDecodeIcon(t *testing.T) {
+
+	transparent := color.RGBA{}
+	red := color.RGBA{0xff, 0, 0, 0xff}
+	blue := color.RGBA{0, 0, 0xff, 0xff}
+	green := color.RGBA{0, 0xff, 0, 0xff}
+
+	type pixel struct {
+		x, y int
+		want color.RGBA
+	}
+
+	tests := []struct {
+		name   string
+		data   []byte
+		size   int
+		pixels []pixel
+	}{
+		{"bitmap ico", testIco(), 2, []pixel{{0, 0, transparent}, {1, 0, blue}, {0, 1, green}, {1, 1, green}}},
+		{"png", testPng(t, 8, 8, red), 16, []pixel{{0, 0, red}, {8, 8, red}, {15, 15, red}}},
+		{"wide png", testPng(t, 16, 8, red), 16, []pixel{{0, 0, transparent}, {8, 8, red}, {15, 15, transparent}}},
+		{"svg", []byte(testSvg), 32, []pixel{{0, 0, transparent}, {16, 16, red}, {31, 31, transparent}}},
+	}
+
+	for _, test := range tests {
+		source, err := decodeIcon(test.data)
+
+		if err != nil {
+			t.Errorf("%s: failed to decode: %s", test.name, err)
+			continue
+		}
+
+		img, err := source(test.size)
+
+		if err != nil {
+			t.Errorf("%s: failed to draw: %s", test.name, err)
+			continue
+		}
+
+		if size := img.Bounds().Size(); size != image.Pt(test.size, test.size) {
+			t.Errorf("%s: expected a %dpx icon, got %v", test.name, test.size, size)
+		}
+
+		for _, pixel := range test.pixels {
+			if got := color.RGBAModel.Convert(img.At(pixel.x, pixel.y)); got != pixel.want {
+				t.Errorf("%s: expected %v at %d,%d, got %v", test.name, pixel.want, pixel.x, pixel.y, got)
+			}
+		}
+	}
+
+	// The builtin icons hold several sizes, and the closest one is used.
+	source, err := decodeIcon(icons.KB_Light_Data)
+	if err != nil {
+		t.Fatalf("failed to decode the builtin icon: %s", err)
+	}
+
+	for _, size := range []int{16, 24, 128} {
+		if img, err := source(size); err != nil || img.Bounds().Dx() != size {
+			t.Errorf("expected a %dpx builtin icon, got %v, %v", size, img.Bounds(), err)
+		}
+	}
+}
+
+// Broken icons say what is wrong with them, rather than showing nothing.
+func TestDecodeCorruptIcon(t *testing.T) {
+
+	valid := testPng(t, 4, 4, color.RGBA{})
+
+	shortIco := testIco()
+	binary.LittleEndian.PutUint32(shortIco[6+8:], 4096)
+
+	paletteIco := testIco()
+	binary.LittleEndian.PutUint16(paletteIco[22+14:], 8)
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"truncated png", valid[:len(valid)/2], "invalid PNG image"},
+		{"empty ico", []byte{0, 0, 1, 0, 0, 0}, "invalid ICO image: no images"},
+		{"ico past the end", shortIco, "invalid ICO image: image 0 is outside the file"},
+		{"8-bit ico", paletteIco, "invalid ICO image: image 0: unsupported 8-bit bitmap"},
+		{"svg without a size", []byte(`<svg xmlns="http://www.w3.org/2000/svg"><rect/></svg>`), "invalid SVG image"},
+		{"text", []byte("not an icon"), "unrecognised image format"},
+	}
+
+	for _, test := range tests {
+		_, err := ConvertIcon(test.data)
+
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: expected an error with %q, got %v", test.name, test.want, err)
+		}
+	}
+}
+
+// Every supported format converts to an icon the tray can show.
+func TestConvertIcon(t *testing.T) {
+
+	inputs := map[string][]byte{
+		"builtin": icons.KB_Dark_Data,
+		"ico":     testIco(),
+		"png":     testPng(t, 48, 48, color.RGBA{0, 0, 0xff, 0xff}),
+		"svg":     []byte(testSvg),
+	}
+
+	want := DetectIconFormat(iconForTray(t))
+
+	for name, data := range inputs {
+		converted, err := ConvertIcon(data)
+
+		if err != nil {
+			t.Errorf("%s: failed to convert: %s", name, err)
+			continue
+		}
+
+		if format := DetectIconFormat(converted); format != want {
+			t.Errorf("%s: expected the tray format %s, got %s", name, want, format)
+		}
+
+		if _, err := decodeIcon(converted); err != nil {
+			t.Errorf("%s: converted icon can't be read back: %s", name, err)
+		}
+	}
+}
+
+// Encode a blank icon, to find the format the tray expects.
+func iconForTray(t *testing.T) []byte {
+
+	t.Helper()
+
+	data, err := encodeIcon(func(size int) (image.Image, error) {
+		return image.NewRGBA(image.Rect(0, 0, size, size)), nil
+	})
+
+	if err != nil {
+		t.Fatalf("failed to encode a blank icon: %s", err)
+	}
+
+	return data
+}
@@ -0,0 +1,291 @@
+package tray
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// The most characters a generated icon can fit, and still be readable.
+const maxIconText = 3
+
+// The shapes generated icons can be drawn on.
+var iconShapes = []string{"rounded", "square", "circle"}
+
+// An icon, either the name of a built in icon or icon file, or a spec to
+// generate one from, i.e. {"text": "G", "fg": "#fff", "bg": "#c62828"}.
+type IconSpec struct {
+	Name  string `json:"-"`
+	Text  string `json:"text"`
+	Fg    string `json:"fg,omitempty"`
+	Bg    string `json:"bg,omitempty"`
+	Shape string `json:"shape,omitempty"`
+}
+
+// An icon spec can be given as just the name of an icon, as in older configs,
+// or as an object describing the icon to generate.
+func (spec *IconSpec) UnmarshalJSON(data []byte) error {
+
+	name := ""
+	if err := json.Unmarshal(data, &name); err == nil {
+		*spec = IconSpec{Name: name}
+		return nil
+	}
+
+	// Use a different type, so this method isn't called again.
+	type generatedSpec IconSpec
+	generated := generatedSpec{}
+
+	if err := json.Unmarshal(data, &generated); err != nil {
+		return fmt.Errorf("icon should be a name or an object: %w", err)
+	}
+
+	*spec = IconSpec(generated)
+	spec.Name = ""
+
+	return nil
+}
+
+func (spec IconSpec) MarshalJSON() ([]byte, error) {
+
+	if !spec.IsGenerated() {
+		return json.Marshal(spec.Name)
+	}
+
+	type generatedSpec IconSpec
+	return json.Marshal(generatedSpec(spec))
+}
+
+// Should the icon be generated, rather than loaded.
+func (spec IconSpec) IsGenerated() bool {
+	return spec.Name == "" && spec.Text != ""
+}
+
+// Is there no icon given at all.
+func (spec IconSpec) IsEmpty() bool {
+	return spec.Name == "" && !spec.IsGenerated()
+}
+
+func (spec IconSpec) String() string {
+
+	if spec.IsGenerated() {
+		return fmt.Sprintf("%q icon", spec.Text)
+	}
+
+	return spec.Name
+}
+
+// Load the icon for the given theme. Generated icons get a variant for each
+// theme, but icon files are the same for both.
+func LoadIcon(spec IconSpec, dark bool) ([]byte, error) {
+
+	if !spec.IsGenerated() {
+		return ParseIcon(spec.Name)
+	}
+
+	return encodeIcon(func(size int) (image.Image, error) {
+		return RenderIcon(spec, dark, size)
+	})
+}
+
+// Draw an icon from its spec. Any colour that isn't given is picked to stand
+// out against the tray, or the background of the icon.
+func RenderIcon(spec IconSpec, dark bool, size int) (image.Image, error) {
+
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+
+	bg := color.RGBA{}
+	if spec.Bg != "" {
+		parsed, err := ParseColor(spec.Bg)
+		if err != nil {
+			return nil, err
+		}
+
+		bg = parsed
+	}
+
+	// Without a background the text sits straight on the tray, so follow the theme.
+	fg := color.RGBA{0, 0, 0, 0xff}
+	if spec.Fg != "" {
+		parsed, err := ParseColor(spec.Fg)
+		if err != nil {
+			return nil, err
+		}
+
+		fg = parsed
+	} else if bg.A != 0 && luminance(bg) < 0.5 || bg.A == 0 && dark {
+		fg = color.RGBA{0xff, 0xff, 0xff, 0xff}
+	}
+
+	shape := strings.ToLower(spec.Shape)
+	if shape == "" {
+		shape = "rounded"
+	} else if !slices.Contains(iconShapes, shape) {
+		return nil, fmt.Errorf("unknown icon shape %q, should be one of %s", spec.Shape, strings.Join(iconShapes, ", "))
+	}
+
+	if bg.A != 0 {
+		drawShape(img, shape, bg)
+	}
+
+	err := drawText(img, spec.Text, fg)
+	if err != nil {
+		return nil, err
+	}
+
+	return img, nil
+}
+
+// Parse a hex colour, in the #rgb, #rgba, #rrggbb or #rrggbbaa form.
+func ParseColor(value string) (color.RGBA, error) {
+
+	digits := strings.TrimPrefix(value, "#")
+
+	// Expand the short forms, so every channel is two digits.
+	if len(digits) == 3 || len(digits) == 4 {
+		long := ""
+		for _, digit := range digits {
+			long += strings.Repeat(string(digit), 2)
+		}
+
+		digits = long
+	}
+
+	if len(digits) == 6 {
+		digits += "ff"
+	}
+
+	channels, err := hex.DecodeString(digits)
+
+	if err != nil || len(channels) != 4 || !strings.HasPrefix(value, "#") {
+		return color.RGBA{}, fmt.Errorf("invalid colour %q, should look like #rrggbb", value)
+	}
+
+	return color.RGBA{channels[0], channels[1], channels[2], channels[3]}, nil
+}
+
+// The relative luminance of a colour, from 0 for black to 1 for white.
+func luminance(c color.RGBA) float64 {
+	return (0.2126*float64(c.R) + 0.7152*float64(c.G) + 0.0722*float64(c.B)) / 0xff
+}
+
+// Fill the icon with the background shape, anti-aliasing the edges by
+// sampling each pixel a few times.
+func drawShape(img *image.RGBA, shape string, bg color.RGBA) {
+
+	const samples = 4
+
+	size := float64(img.Bounds().Dx())
+	radius := size / 2
+
+	if shape == "rounded" {
+		radius = size / 5
+	} else if shape == "square" {
+		radius = 0
+	}
+
+	inside := func(x float64, y float64) bool {
+
+		// Distance from the nearest corner circle, for anything in a corner.
+		dx := math.Max(radius-x, x-(size-radius))
+		dy := math.Max(radius-y, y-(size-radius))
+
+		if dx <= 0 || dy <= 0 {
+			return true
+		}
+
+		return dx*dx+dy*dy <= radius*radius
+	}
+
+	for y := 0; y < img.Bounds().Dy(); y++ {
+		for x := 0; x < img.Bounds().Dx(); x++ {
+			covered := 0
+
+			for sy := 0; sy < samples; sy++ {
+				for sx := 0; sx < samples; sx++ {
+					if inside(float64(x)+(float64(sx)+0.5)/samples, float64(y)+(float64(sy)+0.5)/samples) {
+						covered++
+					}
+				}
+			}
+
+			if covered == 0 {
+				continue
+			}
+
+			// The image is premultiplied, so scale every channel by the coverage.
+			scale := func(v uint8) uint8 {
+				return uint8(int(v) * covered / (samples * samples))
+			}
+
+			alpha := uint32(bg.A)
+			img.SetRGBA(x, y, color.RGBA{
+				scale(uint8(uint32(bg.R) * alpha / 0xff)),
+				scale(uint8(uint32(bg.G) * alpha / 0xff)),
+				scale(uint8(uint32(bg.B) * alpha / 0xff)),
+				scale(bg.A),
+			})
+		}
+	}
+}
+
+// Draw the text in the middle of the icon, as large as will fit.
+func drawText(img *image.RGBA, text string, fg color.RGBA) error {
+
+	parsed, err := opentype.Parse(gobold.TTF)
+	if err != nil {
+		return err
+	}
+
+	size := float64(img.Bounds().Dx())
+	maxSize := size * 0.8
+
+	// Start at a size that fills the icon, and shrink until the text fits.
+	var face font.Face
+	var bounds fixed.Rectangle26_6
+
+	for points := size * 0.75; ; points -= 2 {
+		face, err = opentype.NewFace(parsed, &opentype.FaceOptions{Size: points, DPI: 72, Hinting: font.HintingFull})
+		if err != nil {
+			return err
+		}
+
+		bounds, _ = font.BoundString(face, text)
+		width := float64(bounds.Max.X-bounds.Min.X) / 64
+		height := float64(bounds.Max.Y-bounds.Min.Y) / 64
+
+		if width <= maxSize && height <= maxSize || points <= 8 {
+			break
+		}
+
+		face.Close()
+	}
+
+	defer face.Close()
+
+	// Centre the glyphs themselves, rather than the line they sit on.
+	width := bounds.Max.X - bounds.Min.X
+	height := bounds.Max.Y - bounds.Min.Y
+	centre := fixed.I(int(size) / 2)
+
+	drawer := font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(fg),
+		Face: face,
+		Dot:  fixed.Point26_6{X: centre - width/2 - bounds.Min.X, Y: centre - height/2 - bounds.Min.Y},
+	}
+
+	drawer.DrawString(text)
+
+	return nil
+}
@@ -0,0 +1,184 @@
+package tray
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestParseColor(t *testing.T) {
+
+	tests := []struct {
+		value string
+		want  color.RGBA
+		fails bool
+	}{
+		{"#c62828", color.RGBA{0xc6, 0x28, 0x28, 0xff}, false},
+		{"#FFF", color.RGBA{0xff, 0xff, 0xff, 0xff}, false},
+		{"#0008", color.RGBA{0, 0, 0, 0x88}, false},
+		{"#11223344", color.RGBA{0x11, 0x22, 0x33, 0x44}, false},
+		{"c62828", color.RGBA{}, true},
+		{"#c6282", color.RGBA{}, true},
+		{"#ggg", color.RGBA{}, true},
+		{"red", color.RGBA{}, true},
+		{"", color.RGBA{}, true},
+	}
+
+	for _, test := range tests {
+		got, err := ParseColor(test.value)
+
+		if (err != nil) != test.fails || got != test.want {
+			t.Errorf("ParseColor(%q) = %v, %v, want %v (fails %t)", test.value, got, err, test.want, test.fails)
+		}
+	}
+}
+
+func TestIconSpecJSON(t *testing.T) {
+
+	tests := []struct {
+		json      string
+		want      IconSpec
+		generated bool
+	}{
+		{`"kb_dark"`, IconSpec{Name: "kb_dark"}, false},
+		{`"gaming.ico"`, IconSpec{Name: "gaming.ico"}, false},
+		{`{"text": "G", "fg": "#fff", "bg": "#c62828", "shape": "circle"}`, IconSpec{Text: "G", Fg: "#fff", Bg: "#c62828", Shape: "circle"}, true},
+		{`{"text": ""}`, IconSpec{}, false},
+	}
+
+	for _, test := range tests {
+		spec := IconSpec{}
+
+		if err := json.Unmarshal([]byte(test.json), &spec); err != nil {
+			t.Errorf("%s: failed to parse: %s", test.json, err)
+			continue
+		}
+
+		if spec != test.want || spec.IsGenerated() != test.generated {
+			t.Errorf("%s: got %+v (generated %t), want %+v", test.json, spec, spec.IsGenerated(), test.want)
+		}
+
+		// Writing the spec back out keeps the form it was given in.
+		data, err := json.Marshal(spec)
+		again := IconSpec{}
+
+		if err != nil || json.Unmarshal(data, &again) != nil || again != spec {
+			t.Errorf("%s: did not survive being written out as %s", test.json, data)
+		}
+	}
+
+	if err := json.Unmarshal([]byte(`["G"]`), &IconSpec{}); err == nil {
+		t.Errorf("expected a list to not be an icon")
+	}
+}
+
+// Count the pixels that are close to the given colour.
+func countPixels(img image.Image, want color.RGBA) int {
+
+	count := 0
+	bounds := img.Bounds()
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			got := color.RGBAModel.Convert(img.At(x, y)).(color.RGBA)
+			near := func(a, b uint8) bool { return int(a)-int(b) < 8 && int(b)-int(a) < 8 }
+
+			if near(got.R, want.R) && near(got.G, want.G) && near(got.B, want.B) && near(got.A, want.A) {
+				count++
+			}
+		}
+	}
+
+	return count
+}
+
+func TestRenderIcon(t *testing.T) {
+
+	white := color.RGBA{0xff, 0xff, 0xff, 0xff}
+	black := color.RGBA{0, 0, 0, 0xff}
+	red := color.RGBA{0xc6, 0x28, 0x28, 0xff}
+	yellow := color.RGBA{0xff, 0xeb, 0x3b, 0xff}
+
+	tests := []struct {
+		name   string
+		spec   IconSpec
+		dark   bool
+		corner color.RGBA
+		fg     color.RGBA
+	}{
+		{"rounded", IconSpec{Text: "G", Bg: "#c62828"}, false, color.RGBA{}, white},
+		{"square", IconSpec{Text: "G", Bg: "#c62828", Shape: "Square"}, false, red, white},
+		{"circle", IconSpec{Text: "G", Bg: "#c62828", Shape: "circle"}, false, color.RGBA{}, white},
+		{"light background", IconSpec{Text: "G", Bg: "#ffeb3b", Shape: "square"}, true, yellow, black},
+		{"no background", IconSpec{Text: "G"}, false, color.RGBA{}, black},
+		{"no background, dark", IconSpec{Text: "G"}, true, color.RGBA{}, white},
+		{"given colours", IconSpec{Text: "ABC", Fg: "#ffeb3b", Bg: "#000"}, false, color.RGBA{}, yellow},
+	}
+
+	for _, test := range tests {
+		img, err := RenderIcon(test.spec, test.dark, 64)
+
+		if err != nil {
+			t.Errorf("%s: failed to render: %s", test.name, err)
+			continue
+		}
+
+		if size := img.Bounds().Size(); size != image.Pt(64, 64) {
+			t.Errorf("%s: expected a 64x64 icon, got %v", test.name, size)
+		}
+
+		if corner := color.RGBAModel.Convert(img.At(0, 0)); corner != test.corner {
+			t.Errorf("%s: expected the corner to be %v, got %v", test.name, test.corner, corner)
+		}
+
+		if count := countPixels(img, test.fg); count < 50 {
+			t.Errorf("%s: expected the text to be drawn in %v, only found %d pixels", test.name, test.fg, count)
+		}
+	}
+}
+
+func TestRenderIconErrors(t *testing.T) {
+
+	specs := []IconSpec{
+		{Text: "G", Bg: "red"},
+		{Text: "G", Fg: "#12345"},
+		{Text: "G", Shape: "star"},
+	}
+
+	for _, spec := range specs {
+		if _, err := RenderIcon(spec, false, 32); err == nil {
+			t.Errorf("expected %+v to fail", spec)
+		}
+
+		if _, err := LoadIcon(spec, false); err == nil {
+			t.Errorf("expected loading %+v to fail", spec)
+		}
+	}
+}
+
+func TestLoadGeneratedIcon(t *testing.T) {
+
+	spec := IconSpec{Text: "N"}
+
+	light, err := LoadIcon(spec, false)
+	if err != nil {
+		t.Fatalf("failed to load the light icon: %s", err)
+	}
+
+	dark, err := LoadIcon(spec, true)
+	if err != nil {
+		t.Fatalf("failed to load the dark icon: %s", err)
+	}
+
+	if bytes.Equal(light, dark) {
+		t.Errorf("expected different light and dark icons")
+	}
+
+	// The icon is in the format the tray expects, which is also one that can
+	// be loaded back in.
+	if _, err := decodeIcon(light); err != nil {
+		t.Errorf("generated icon can't be read back: %s", err)
+	}
+}
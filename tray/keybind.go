@@ -1,13 +1,18 @@
 package tray
 
 import (
-	"errors"
 	"fmt"
+	"sync"
+	"time"
 
-	"github.com/getlantern/systray"
 	"golang.design/x/hotkey"
 )
 
+// How long a chord has to stay released before the release is sent. With key
+// repeat, X11 sends a release and press for every repeat while a chord is held,
+// and these have to be ignored.
+const releaseDelay = 100 * time.Millisecond
+
 type Keybinding struct {
 	bind      *hotkey.Hotkey
 	mods      []hotkey.Modifier
@@ -16,40 +21,66 @@ type Keybinding struct {
 	name      string
 	icon      *[]byte
 	dark_icon *[]byte
+	behavior  string
+	group     string
 }
 
-func MakeKeybinding(state *TrayState, binding LayerConfig, i int) Keybinding {
+func MakeKeybinding(state *TrayState, binding LayerConfig, i int) (Keybinding, error) {
 
 	// Parse the configurations strings into its mods / keys and icon.
-	mods := ParseModifiers(binding.Mods)
-	if len(mods) == 0 {
-		state.logger.Printf("Error parsing mods\n")
-		return Keybinding{}
+	mods, err := ParseModifiers(binding.Mods)
+	if err != nil {
+		return Keybinding{}, err
 	}
 
 	key, err := ParseKey(binding.Key)
 	if err != nil {
-		state.logger.Printf("Error parsing key: %s\n", err.Error())
-		return Keybinding{}
+		return Keybinding{}, err
 	}
 
-	icon, err := ParseIcon(binding.Icon)
-	if err != nil {
-		state.logger.Printf("Error parsing icon: %s\n", err.Error())
+	icon := loadLayerIcon(state, binding.Icon, false)
+
+	// Without a dark icon, use the dark variant of the same icon. Only
+	// generated icons have one, so icon files are used for both themes.
+	dark_icon := icon
+	if !binding.DarkIcon.IsEmpty() {
+		dark_icon = loadLayerIcon(state, binding.DarkIcon, true)
+	} else if binding.Icon.IsGenerated() {
+		dark_icon = loadLayerIcon(state, binding.Icon, true)
+	}
+
+	keybind := Keybinding{nil, mods, key, i, binding.Name, &icon, &dark_icon, LayerBehavior(binding), binding.Group}
+
+	return keybind, nil
+}
+
+// Load a layer icon, falling back to the default icon if there is none or it
+// can't be loaded.
+func loadLayerIcon(state *TrayState, spec IconSpec, dark bool) []byte {
+
+	var icon []byte
+	var err error
+
+	if !spec.IsEmpty() {
+		icon, err = LoadIcon(spec, dark)
 	}
 
-	dark_icon, err := ParseIcon(binding.DarkIcon)
 	if err != nil {
 		state.logger.Printf("Error parsing icon: %s\n", err.Error())
 	}
 
-	keybind := Keybinding{nil, mods, key, i, binding.Name, &icon, &dark_icon}
+	if len(icon) == 0 {
+		icon, _ = ParseIcon("kb_light")
+	}
 
-	return keybind
+	return icon
 }
 
-// Setup the actual keybinds...
-func (keybind *Keybinding) SetupKeybinding(state *TrayState) error {
+// Setup the actual keybinds, sending the given event every time the chord is
+// pressed, until the source is stopped. If there is a release event, that is
+// sent every time the chord is released too, unless it is pressed again within
+// the release delay.
+func (keybind *Keybinding) SetupKeybinding(source *HotkeySource, press LayerEvent, release *LayerEvent) error {
 
 	hk := hotkey.New(keybind.mods, keybind.key)
 	err := hk.Register()
@@ -58,86 +89,195 @@ func (keybind *Keybinding) SetupKeybinding(state *TrayState) error {
 		return err
 	}
 
-	go func() {
-		for hk != nil {
-			<-hk.Keydown()
+	// Unregistering closes these channels, which ends the loop below. They are
+	// read once up front, since unregistering also swaps in new ones.
+	keydown := hk.Keydown()
+
+	// Without a release event, never wait on the key up channel.
+	var keyup <-chan hotkey.Event
+	if release != nil {
+		keyup = hk.Keyup()
+	}
+
+	source.running.Add(1)
+	go source.forward(keydown, keyup, press, release)
+
+	keybind.bind = hk
 
-			if state.quitting {
-				break
+	return nil
+}
+
+// Turn the presses and releases of a single chord into events, until either
+// channel is closed or the source is stopped.
+func (source *HotkeySource) forward(keydown, keyup <-chan hotkey.Event, press LayerEvent, release *LayerEvent) {
+
+	defer source.running.Done()
+
+	// The pending release, if the chord was let go of.
+	var released <-chan time.Time
+
+	for {
+		event := press
+
+		select {
+		case _, ok := <-keydown:
+			if !ok {
+				return
 			}
 
-			// If we are already in this layer, do nothing.
-			if state.layer_id == keybind.id {
+			// Pressed again before the release was sent, so it is still
+			// held and nothing changed.
+			if released != nil {
+				released = nil
 				continue
 			}
+		case _, ok := <-keyup:
+			if !ok {
+				return
+			}
 
-			// Update the tray icon and title.
-			state.tray.layer.SetTitle(fmt.Sprintf("%s Layer", keybind.name))
-			systray.SetIcon(*keybind.GetIcon(state))
+			released = time.After(releaseDelay)
+			continue
+		case <-released:
+			released = nil
+			event = *release
+		case <-source.done:
+			return
+		}
 
-			// Make sure the app state is saved.
-			state.layer_id = keybind.id
-			state.layer_name = keybind.name
+		select {
+		case source.events <- event:
+		case <-source.done:
+			return
 		}
-	}()
+	}
+}
 
-	keybind.bind = hk
+// Global hotkeys as a layer source, for a single keyboard.
+// Each layer binding moves to its layer, each flag binding sets, clears or
+// toggles its flag, and each output binding swaps to its output.
+type HotkeySource struct {
+	state    *TrayState
+	keyboard *Keyboard
+	keybinds []*Keybinding
+	events   chan LayerEvent
+	done     chan struct{}
+	running  sync.WaitGroup
+}
 
-	return nil
+func NewHotkeySource(state *TrayState, keyboard *Keyboard) *HotkeySource {
+	return &HotkeySource{state: state, keyboard: keyboard}
 }
 
-// A small helper function that just toggles the disconnected icon.
-// I.e., when the board swaps output to another device, swap to an icon
-// that shows this disconnected state.
-func SetupConnectKeybind(state *TrayState, config *Config) (Keybinding, error) {
+func (source *HotkeySource) Start() error {
 
-	mods := ParseModifiers(config.ConnectMods)
-	if len(mods) == 0 {
-		return Keybinding{}, errors.New("connect toggle keybind declared with no modifiers")
-	}
+	state := source.state
+	keyboard := source.keyboard
+	source.events = make(chan LayerEvent)
+	source.done = make(chan struct{})
 
-	key, err := ParseKey(config.ConnectKey)
-	if err != nil {
-		return Keybinding{}, errors.New("failed to parse connect toggle key")
-	}
+	// Register the actual layer bindings.
+	for i := range keyboard.keybinds {
 
-	keybind := Keybinding{nil, mods, key, -1, "Connect Toggle", nil, nil}
-	hk := hotkey.New(keybind.mods, keybind.key)
-	err = hk.Register()
+		keybind := &keyboard.keybinds[i]
+		press := LayerEvent{Kind: LayerChanged, LayerId: keybind.id, LayerName: keybind.name, Keyboard: keyboard.name}
+		var release *LayerEvent
 
-	if err != nil {
-		return Keybinding{}, errors.New("connect toggle keybind failed to register")
+		switch keybind.behavior {
+		case BehaviorToggle:
+			press.Kind = LayerToggled
+		case BehaviorMomentary:
+			// Momentary layers only last while the chord is held.
+			press.Kind = LayerHeld
+			release = &LayerEvent{Kind: LayerReleased, LayerId: keybind.id, LayerName: keybind.name, Keyboard: keyboard.name}
+		}
+
+		err := keybind.SetupKeybinding(source, press, release)
+
+		if err != nil {
+			state.logger.Printf("Error setting up keybind %d: %s\n", keybind.id, err.Error())
+			continue
+		}
+
+		// Store the binding, so we can unregister it later.
+		source.keybinds = append(source.keybinds, keybind)
 	}
 
-	go func() {
-		for hk != nil {
-			<-hk.Keydown()
+	for _, flag := range keyboard.flags {
+		chords := []*ChordConfig{flag.config.Set, flag.config.Clear, flag.config.Toggle}
+		events := []LayerEvent{
+			{Kind: FlagChanged, Keyboard: keyboard.name, Flag: flag.name, Value: true},
+			{Kind: FlagChanged, Keyboard: keyboard.name, Flag: flag.name, Value: false},
+			{Kind: FlagToggled, Keyboard: keyboard.name, Flag: flag.name},
+		}
+
+		for i, chord := range chords {
+			if chord == nil {
+				continue
+			}
+
+			flagBinding, err := MakeChordKeybinding(*chord, fmt.Sprintf("%s flag", flag.name))
+			if err == nil {
+				err = flagBinding.SetupKeybinding(source, events[i], nil)
+			}
 
-			if state.quitting {
-				break
+			if err != nil {
+				state.logger.Printf("Failed to create %s flag keybind: %s\n", flag.name, err.Error())
+				continue
 			}
 
-			// Update the tray icon and title.
-			bind := (*state.keybinds)[state.layer_id]
-			state.is_connected = !state.is_connected
-			systray.SetIcon(*bind.GetIcon(state))
+			source.keybinds = append(source.keybinds, &flagBinding)
 		}
-	}()
+	}
 
-	keybind.bind = hk
+	for _, output := range keyboard.outputs {
+		if output.config.Key == "" {
+			continue
+		}
 
-	return keybind, nil
+		outputBinding, err := MakeChordKeybinding(ChordConfig{output.config.Mods, output.config.Key}, fmt.Sprintf("%s output", output.name))
+		if err == nil {
+			err = outputBinding.SetupKeybinding(source, LayerEvent{Kind: OutputChanged, Keyboard: keyboard.name, Output: output.name}, nil)
+		}
+
+		if err != nil {
+			state.logger.Printf("Failed to create %s output keybind: %s\n", output.name, err.Error())
+			continue
+		}
+
+		source.keybinds = append(source.keybinds, &outputBinding)
+	}
+
+	return nil
 }
 
-// Get the current app icon.
-func (keybind *Keybinding) GetIcon(state *TrayState) *[]byte {
-	if state.is_connected {
-		if state.dark_mode {
-			return keybind.dark_icon
-		} else {
-			return keybind.icon
+// Un-register every keybinding, then stop sending events. The bindings go
+// first, so nothing is read from their closed channels as a key press.
+func (source *HotkeySource) Stop() error {
+
+	for _, hk := range source.keybinds {
+		err := hk.bind.Unregister()
+
+		if err != nil {
+			source.state.logger.Println("Failed to unregister keybind:", err.Error())
 		}
-	} else {
-		return state.disconnect_icon
+
+		hk.bind = nil
 	}
+
+	source.keybinds = nil
+	close(source.done)
+	source.running.Wait()
+	close(source.events)
+
+	return nil
+}
+
+func (source *HotkeySource) Events() <-chan LayerEvent {
+	return source.events
+}
+
+// Get the current app icon.
+func (keybind *Keybinding) GetIcon(keyboard *Keyboard) *[]byte {
+	return keyboard.pickIcon(keybind.icon, keybind.dark_icon)
 }
@@ -0,0 +1,129 @@
+package tray
+
+import (
+	"testing"
+	"time"
+
+	"golang.design/x/hotkey"
+)
+
+func TestLayerBehavior(t *testing.T) {
+
+	tests := []struct {
+		binding LayerConfig
+		want    string
+	}{
+		{LayerConfig{}, BehaviorTo},
+		{LayerConfig{Momentary: true}, BehaviorMomentary},
+		{LayerConfig{Behavior: "Toggle"}, BehaviorToggle},
+		{LayerConfig{Behavior: "to", Momentary: true}, BehaviorTo},
+	}
+
+	for _, test := range tests {
+		if got := LayerBehavior(test.binding); got != test.want {
+			t.Errorf("LayerBehavior(%+v) = %q, want %q", test.binding, got, test.want)
+		}
+	}
+}
+
+// Expect the next event from the source, or none at all if want is nil.
+func expectEvent(t *testing.T, source *HotkeySource, want *LayerEvent, within time.Duration) {
+
+	t.Helper()
+
+	select {
+	case event := <-source.events:
+		if want == nil {
+			t.Fatalf("expected no event, got %+v", event)
+		} else if event != *want {
+			t.Fatalf("expected %+v, got %+v", *want, event)
+		}
+	case <-time.After(within):
+		if want != nil {
+			t.Fatalf("expected %+v, got nothing", *want)
+		}
+	}
+}
+
+// A held chord sends its release once it has stayed up for the release delay,
+// and key repeat in between is ignored.
+func TestMomentaryChordRelease(t *testing.T) {
+
+	source := &HotkeySource{events: make(chan LayerEvent), done: make(chan struct{})}
+	keydown := make(chan hotkey.Event)
+	keyup := make(chan hotkey.Event)
+
+	press := LayerEvent{Kind: LayerHeld, LayerId: 3, LayerName: "Fn"}
+	release := LayerEvent{Kind: LayerReleased, LayerId: 3, LayerName: "Fn"}
+
+	source.running.Add(1)
+	go source.forward(keydown, keyup, press, &release)
+
+	keydown <- hotkey.Event{}
+	expectEvent(t, source, &press, time.Second)
+
+	// Key repeat, which is a release and press straight after each other.
+	for i := 0; i < 3; i++ {
+		keyup <- hotkey.Event{}
+		keydown <- hotkey.Event{}
+	}
+
+	expectEvent(t, source, nil, 2*releaseDelay)
+
+	released := time.Now()
+	keyup <- hotkey.Event{}
+	expectEvent(t, source, &release, time.Second)
+
+	if elapsed := time.Since(released); elapsed < releaseDelay {
+		t.Errorf("expected the release to wait %s, sent after %s", releaseDelay, elapsed)
+	}
+
+	// Pressing it again holds it again.
+	keydown <- hotkey.Event{}
+	expectEvent(t, source, &press, time.Second)
+
+	close(source.done)
+	source.running.Wait()
+}
+
+// Closing the chord channels, like unregistering does, ends the forwarding.
+func TestChordForwardingEndsWhenClosed(t *testing.T) {
+
+	source := &HotkeySource{events: make(chan LayerEvent), done: make(chan struct{})}
+	keydown := make(chan hotkey.Event)
+
+	source.running.Add(1)
+	go source.forward(keydown, nil, LayerEvent{Kind: LayerChanged, LayerName: "Base"}, nil)
+
+	close(keydown)
+	source.running.Wait()
+}
+
+// A held layer is never saved, since it can't still be held next time, so
+// the layers under it are saved instead.
+func TestMomentaryLayersAreNotSaved(t *testing.T) {
+
+	state := newTestState(t, `{"layers": [
+		{"name": "Base", "mods": "ctrl-shift", "key": "F1", "icon": {"text": "B"}},
+		{"name": "Nav", "mods": "ctrl-shift", "key": "F2", "icon": {"text": "N"}, "behavior": "toggle"},
+		{"name": "Fn", "mods": "ctrl-shift", "key": "F3", "icon": {"text": "F"}, "momentary": true}
+	]}`)
+
+	var current, saved SaveState
+
+	state.loop.Do(func() {
+		state.HandleEvent(LayerEvent{Kind: LayerToggled, LayerName: "Nav"})
+		state.HandleEvent(LayerEvent{Kind: LayerHeld, LayerName: "Fn"})
+
+		current = state.keyboards[0].CurrentState()
+		saved = state.keyboards[0].savedState()
+	})
+
+	if current.LayerName != "Fn" || len(current.Stack) != 3 {
+		t.Errorf("expected to show the held layer, got %+v", current)
+	}
+
+	if saved.LayerName != "Nav" || saved.LayerId != 1 || len(saved.Stack) != 2 {
+		t.Errorf("expected to save the layers under the held one, got %+v", saved)
+	}
+}
@@ -0,0 +1,345 @@
+package tray
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"strings"
+
+	"github.com/getlantern/systray"
+	"golang.org/x/exp/slices"
+)
+
+// A single keyboard, with its own layers and flags.
+type Keyboard struct {
+	state       *TrayState
+	name        string
+	config      *KeyboardConfig
+	keybinds    []Keybinding
+	layer_id    int
+	layer_name  string
+	layer_stack []int
+	layer_items map[int]*systray.MenuItem
+	groups      []string
+	combos      []LayerCombo
+	flags       []*Flag
+	outputs     []*Output
+	output      string
+}
+
+// An icon for a combination of active layers.
+type LayerCombo struct {
+	layers    []string
+	icon      *[]byte
+	dark_icon *[]byte
+}
+
+// Build the keyboards for a config, parsing every layer binding out of each.
+// Any layer that fails to parse is left out, and reported in the error.
+func MakeKeyboards(state *TrayState, config *Config) ([]*Keyboard, error) {
+
+	keyboards := []*Keyboard{}
+	errs := []error{}
+
+	for _, keyboardConfig := range config.KeyboardConfigs() {
+		keybinds, err := MakeKeybindings(state, keyboardConfig)
+
+		if err != nil && keyboardConfig.Name != "" {
+			errs = append(errs, fmt.Errorf("keyboard %s: %w", keyboardConfig.Name, err))
+		} else if err != nil {
+			errs = append(errs, err)
+		}
+
+		keyboard := &Keyboard{
+			state:    state,
+			name:     keyboardConfig.Name,
+			config:   keyboardConfig,
+			keybinds: keybinds,
+			groups:   layerGroups(keybinds),
+			flags:    MakeFlags(state, keyboardConfig),
+			outputs:  MakeOutputs(state, keyboardConfig),
+		}
+
+		for _, combo := range keyboardConfig.Combos {
+			icon := loadLayerIcon(state, combo.Icon, false)
+
+			dark_icon := icon
+			if !combo.DarkIcon.IsEmpty() {
+				dark_icon = loadLayerIcon(state, combo.DarkIcon, true)
+			} else if combo.Icon.IsGenerated() {
+				dark_icon = loadLayerIcon(state, combo.Icon, true)
+			}
+
+			keyboard.combos = append(keyboard.combos, LayerCombo{combo.Layers, &icon, &dark_icon})
+		}
+
+		keyboards = append(keyboards, keyboard)
+	}
+
+	return keyboards, errors.Join(errs...)
+}
+
+// Find a keyboard by name. An empty name is the first keyboard, so anything
+// that only knows about a single keyboard keeps working.
+func (state *TrayState) FindKeyboard(name string) *Keyboard {
+
+	if name == "" && len(state.keyboards) != 0 {
+		return state.keyboards[0]
+	}
+
+	i := slices.IndexFunc(state.keyboards, func(k *Keyboard) bool {
+		return k.name == name
+	})
+
+	if i == -1 {
+		return nil
+	}
+
+	return state.keyboards[i]
+}
+
+// Prefix some text with the keyboard name, if there is more than one keyboard
+// to tell apart.
+func (keyboard *Keyboard) label(text string) string {
+
+	if len(keyboard.state.keyboards) < 2 || keyboard.name == "" {
+		return text
+	}
+
+	return fmt.Sprintf("%s: %s", keyboard.name, text)
+}
+
+// Find the layer keybinding with the given name, or failing that the given id.
+func (keyboard *Keyboard) FindLayer(id int, name string) *Keybinding {
+	i := slices.IndexFunc(keyboard.keybinds, func(k Keybinding) bool {
+		if name != "" {
+			return k.name == name
+		}
+
+		return k.id == id
+	})
+
+	if i == -1 {
+		return nil
+	}
+
+	return &keyboard.keybinds[i]
+}
+
+// Get the config for a layer, to look up its hooks and other settings.
+func (keyboard *Keyboard) layerConfig(keybind *Keybinding) *LayerConfig {
+
+	if keybind == nil || keybind.id < 0 || keybind.id >= len(keyboard.config.LayerInfo) {
+		return nil
+	}
+
+	return &keyboard.config.LayerInfo[keybind.id]
+}
+
+// Get a snapshot of the current layer and connection state of the keyboard.
+func (keyboard *Keyboard) CurrentState() SaveState {
+
+	// Only list the groups when there is more than one, since otherwise it is
+	// just the current layer again.
+	groups := []GroupState(nil)
+
+	if len(keyboard.groups) > 1 {
+		for i, keybind := range keyboard.activeLayers() {
+			if keybind != nil {
+				groups = append(groups, GroupState{keyboard.groups[i], keybind.name})
+			}
+		}
+	}
+
+	return SaveState{
+		LayerId:     keyboard.layer_id,
+		LayerName:   keyboard.layer_name,
+		IsConnected: keyboard.connected(),
+		Stack:       keyboard.stackNames(true),
+		Groups:      groups,
+		Flags:       keyboard.flagValues(),
+		Output:      keyboard.output,
+		Keyboard:    keyboard.name,
+	}
+}
+
+// Get the name of every layer group, in the order they are first used in the
+// config. Layers without a group are all in the "" group.
+func layerGroups(keybinds []Keybinding) []string {
+
+	groups := []string{}
+
+	for _, keybind := range keybinds {
+		if !slices.Contains(groups, keybind.group) {
+			groups = append(groups, keybind.group)
+		}
+	}
+
+	return groups
+}
+
+// Get the active layer of a group, which is the highest of its layers on the
+// stack.
+func (keyboard *Keyboard) activeLayer(group string) *Keybinding {
+
+	for i := len(keyboard.layer_stack) - 1; i >= 0; i-- {
+		keybind := keyboard.FindLayer(keyboard.layer_stack[i], "")
+
+		if keybind != nil && keybind.group == group {
+			return keybind
+		}
+	}
+
+	return nil
+}
+
+// Get the active layer of every group, in the same order as the groups.
+func (keyboard *Keyboard) activeLayers() []*Keybinding {
+
+	active := []*Keybinding{}
+
+	for _, group := range keyboard.groups {
+		active = append(active, keyboard.activeLayer(group))
+	}
+
+	return active
+}
+
+// Get the names of the active layers, i.e. "Mac · Gaming".
+func (keyboard *Keyboard) title() string {
+
+	names := []string{}
+
+	for _, keybind := range keyboard.activeLayers() {
+		if keybind != nil {
+			names = append(names, keybind.name)
+		}
+	}
+
+	return strings.Join(names, " · ")
+}
+
+// Get the icon for the current state of the keyboard, with the icons of its
+// output and flags drawn on top. A flag or output that replaces the icon is
+// shown in place of the layer icon instead.
+func (keyboard *Keyboard) icon() []byte {
+
+	icon := *keyboard.layerIcon()
+	overlays := [][]byte{}
+	replaced := false
+
+	add := func(extra []byte, replace bool) {
+		if len(extra) == 0 {
+			return
+		} else if replace && !replaced {
+			icon = extra
+			replaced = true
+		} else {
+			overlays = append(overlays, extra)
+		}
+	}
+
+	for _, flag := range keyboard.flags {
+		add(flag.currentIcon(keyboard), flag.config.Replace)
+	}
+
+	add(keyboard.outputIcon())
+
+	if len(overlays) == 0 || len(icon) == 0 {
+		return icon
+	}
+
+	combined, err := overlayIcons(icon, overlays)
+
+	if err != nil {
+		keyboard.state.logger.Printf("Failed to draw flag icons: %s\n", err.Error())
+		return icon
+	}
+
+	return combined
+}
+
+// Get the icon for the active layers. If a combo matches the active layers,
+// its icon is used, picking the one that matches the most layers. Otherwise,
+// the icon of the layer on top of the stack is used.
+func (keyboard *Keyboard) layerIcon() *[]byte {
+
+	active := []string{}
+	for _, keybind := range keyboard.activeLayers() {
+		if keybind != nil {
+			active = append(active, keybind.name)
+		}
+	}
+
+	var best *LayerCombo
+
+	for i, combo := range keyboard.combos {
+		matches := len(combo.layers) != 0 && !slices.ContainsFunc(combo.layers, func(name string) bool {
+			return !slices.Contains(active, name)
+		})
+
+		if matches && (best == nil || len(combo.layers) > len(best.layers)) {
+			best = &keyboard.combos[i]
+		}
+	}
+
+	if best != nil {
+		return keyboard.pickIcon(best.icon, best.dark_icon)
+	}
+
+	keybind := keyboard.FindLayer(keyboard.layer_id, "")
+	if keybind == nil {
+		return &[]byte{}
+	}
+
+	return keybind.GetIcon(keyboard)
+}
+
+// Pick between the light and dark version of an icon.
+func (keyboard *Keyboard) pickIcon(icon *[]byte, dark_icon *[]byte) *[]byte {
+	if keyboard.state.dark_mode {
+		return dark_icon
+	} else {
+		return icon
+	}
+}
+
+// Combine the icons of every keyboard into a single tray icon, since there can
+// only be one, laid out in a grid.
+func combineIcons(icons [][]byte) ([]byte, error) {
+
+	sources := []iconSource{}
+
+	for _, icon := range icons {
+		source, err := decodeIcon(icon)
+		if err != nil {
+			return nil, err
+		}
+
+		sources = append(sources, source)
+	}
+
+	columns := int(math.Ceil(math.Sqrt(float64(len(sources)))))
+	rows := (len(sources) + columns - 1) / columns
+
+	return encodeIcon(func(size int) (image.Image, error) {
+
+		cell := size / columns
+		top := (size - rows*cell) / 2
+		combined := image.NewRGBA(image.Rect(0, 0, size, size))
+
+		for i, source := range sources {
+			img, err := source(cell)
+			if err != nil {
+				return nil, err
+			}
+
+			at := image.Pt(i%columns*cell, top+i/columns*cell)
+			draw.Draw(combined, image.Rectangle{at, at.Add(image.Pt(cell, cell))}, img, img.Bounds().Min, draw.Over)
+		}
+
+		return combined, nil
+	})
+}
@@ -0,0 +1,192 @@
+package tray
+
+import (
+	"testing"
+)
+
+const testKeyboards = `{"keyboards": [
+	{"name": "Sofle", "layers": [
+		{"name": "Base", "mods": "ctrl-shift", "key": "F1", "icon": {"text": "B"}},
+		{"name": "Nav", "mods": "ctrl-shift", "key": "F2", "icon": {"text": "N"}}
+	], "connectMods": "ctrl-shift", "connectKey": "F12"},
+	{"name": "Pad", "layers": [
+		{"name": "Media", "mods": "ctrl-alt", "key": "F1", "icon": {"text": "M"}},
+		{"name": "Macro", "mods": "ctrl-alt", "key": "F2", "icon": {"text": "X"}}
+	]}
+]}`
+
+func TestFindKeyboard(t *testing.T) {
+
+	state := newTestState(t, testKeyboards)
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"", "Sofle"},
+		{"Sofle", "Sofle"},
+		{"Pad", "Pad"},
+		{"pad", ""},
+		{"Lily", ""},
+	}
+
+	state.loop.Do(func() {
+		for _, test := range tests {
+			got := ""
+			if keyboard := state.FindKeyboard(test.name); keyboard != nil {
+				got = keyboard.name
+			}
+
+			if got != test.want {
+				t.Errorf("FindKeyboard(%q) = %q, want %q", test.name, got, test.want)
+			}
+		}
+	})
+}
+
+// Each keyboard has its own layers and connection, and an event only ever
+// changes the keyboard it names.
+func TestKeyboardsAreIndependent(t *testing.T) {
+
+	state := newTestState(t, testKeyboards)
+
+	var current SaveState
+	var titles []string
+
+	state.loop.Do(func() {
+		state.HandleEvent(LayerEvent{Kind: LayerChanged, LayerName: "Macro", Keyboard: "Pad"})
+		state.HandleEvent(LayerEvent{Kind: ConnectToggled, Keyboard: "Sofle"})
+
+		// Neither of these belong to the keyboard they are sent to.
+		state.HandleEvent(LayerEvent{Kind: LayerChanged, LayerName: "Nav", Keyboard: "Pad"})
+		state.HandleEvent(LayerEvent{Kind: LayerChanged, LayerName: "Nav", Keyboard: "Lily"})
+
+		current = state.CurrentState()
+
+		for _, keyboard := range state.keyboards {
+			titles = append(titles, keyboard.label(keyboard.title()))
+		}
+	})
+
+	want := []struct {
+		keyboard  string
+		layer     string
+		connected bool
+	}{
+		{"Sofle", "Base", false},
+		{"Pad", "Macro", true},
+	}
+
+	if len(current.Keyboards) != len(want) {
+		t.Fatalf("expected the state of %d keyboards, got %+v", len(want), current.Keyboards)
+	}
+
+	for i, keyboard := range current.Keyboards {
+		if keyboard.Keyboard != want[i].keyboard || keyboard.LayerName != want[i].layer || keyboard.IsConnected != want[i].connected {
+			t.Errorf("keyboard %d: got %+v, want %+v", i, keyboard, want[i])
+		}
+	}
+
+	// The top level state is the first keyboard, for anything that only
+	// knows about one.
+	if current.Keyboard != "Sofle" || current.LayerName != "Base" {
+		t.Errorf("expected the first keyboard at the top level, got %+v", current)
+	}
+
+	if titles[0] != "Sofle: Base" || titles[1] != "Pad: Macro" {
+		t.Errorf("expected titles labelled by keyboard, got %q", titles)
+	}
+}
+
+// A config without a keyboards list is a single keyboard, without a name.
+func TestSingleKeyboardConfig(t *testing.T) {
+
+	state := newTestState(t, `{"layers": `+testLayers+`}`)
+
+	var current SaveState
+	var title string
+
+	state.loop.Do(func() {
+		state.HandleEvent(LayerEvent{Kind: LayerChanged, LayerId: 1})
+
+		current = state.CurrentState()
+		title = state.keyboards[0].label(state.keyboards[0].title())
+	})
+
+	if current.Keyboard != "" || current.Keyboards != nil || current.LayerName != "Nav" {
+		t.Errorf("expected a single unnamed keyboard on Nav, got %+v", current)
+	}
+
+	if title != "Nav" {
+		t.Errorf("expected the title to not name the keyboard, got %q", title)
+	}
+}
+
+// A layer that fails to parse only leaves out that layer, and the error names
+// the keyboard it is from.
+func TestMakeKeyboards(t *testing.T) {
+
+	useTempDirs(t)
+
+	config, err := ParseConfig([]byte(`{"keyboards": [
+		{"name": "Sofle", "layers": [
+			{"name": "Base", "mods": "ctrl-shift", "key": "F1", "icon": {"text": "B"}},
+			{"name": "Nav", "mods": "ctrl-shfit", "key": "F2", "icon": {"text": "N"}}
+		]},
+		{"name": "Pad", "layers": [
+			{"name": "Media", "mods": "ctrl-alt", "key": "F1", "icon": {"text": "M"}}
+		]}
+	]}`))
+
+	if err != nil {
+		t.Fatalf("failed to parse config: %s", err)
+	}
+
+	state := GetInitialState()
+	t.Cleanup(state.loop.Stop)
+
+	keyboards, err := MakeKeyboards(&state, &config)
+
+	if err == nil || err.Error() != "keyboard Sofle: layer 1 (Nav): unknown modifier: shfit" {
+		t.Errorf("expected the error to name the keyboard, got %v", err)
+	}
+
+	if len(keyboards) != 2 {
+		t.Fatalf("expected 2 keyboards, got %d", len(keyboards))
+	}
+
+	if len(keyboards[0].keybinds) != 1 || keyboards[0].keybinds[0].name != "Base" {
+		t.Errorf("expected only the Base layer on Sofle, got %+v", keyboards[0].keybinds)
+	}
+
+	if len(keyboards[1].keybinds) != 1 || keyboards[1].name != "Pad" {
+		t.Errorf("expected the Pad keyboard to be untouched, got %+v", keyboards[1])
+	}
+}
+
+func TestCombineIcons(t *testing.T) {
+
+	icons := [][]byte{}
+
+	for _, text := range []string{"A", "B", "C"} {
+		icon, err := LoadIcon(IconSpec{Text: text, Bg: "#c62828"}, false)
+		if err != nil {
+			t.Fatalf("failed to load icon: %s", err)
+		}
+
+		icons = append(icons, icon)
+	}
+
+	combined, err := combineIcons(icons)
+	if err != nil {
+		t.Fatalf("failed to combine icons: %s", err)
+	}
+
+	if _, err := decodeIcon(combined); err != nil {
+		t.Errorf("combined icon can't be read back: %s", err)
+	}
+
+	if _, err := combineIcons([][]byte{icons[0], []byte("not an icon")}); err == nil {
+		t.Errorf("expected a broken icon to fail")
+	}
+}
@@ -0,0 +1,119 @@
+package tray
+
+// The predefined flags for the lock key LEDs of the keyboard, which are kept in
+// sync with the LEDs while a led device is set.
+const (
+	CapsLockFlag   = "caps-lock"
+	NumLockFlag    = "num-lock"
+	ScrollLockFlag = "scroll-lock"
+)
+
+// The lock key LEDs of a keyboard.
+type LedState struct {
+	CapsLock   bool
+	NumLock    bool
+	ScrollLock bool
+}
+
+// Get the value of the LED for a flag, if it is one of the LED flags.
+func (leds LedState) flagValue(name string) (bool, bool) {
+
+	switch name {
+	case CapsLockFlag:
+		return leds.CapsLock, true
+	case NumLockFlag:
+		return leds.NumLock, true
+	case ScrollLockFlag:
+		return leds.ScrollLock, true
+	}
+
+	return false, false
+}
+
+// Check if a flag is kept in sync with an LED of the keyboard.
+func (keyboard *Keyboard) followsLed(flag *Flag) bool {
+
+	_, ok := (LedState{}).flagValue(flag.name)
+
+	return ok && keyboard.config.LedDevice != ""
+}
+
+// Get the names of the flags of a keyboard that follow an LED.
+func (keyboard *Keyboard) ledFlags() []string {
+
+	names := []string{}
+
+	for _, flag := range keyboard.flags {
+		if keyboard.followsLed(flag) {
+			names = append(names, flag.name)
+		}
+	}
+
+	return names
+}
+
+// Anything the LED state can be read from, i.e. an evdev device.
+//
+// ReadLeds should return the current state on the first call, then block until
+// the state changes. Close should end any blocked read.
+type LedReader interface {
+	ReadLeds() (LedState, error)
+	Close() error
+}
+
+// The LEDs of a keyboard as a layer source, which keeps every LED flag of the
+// keyboard in sync with its LED.
+type LedSource struct {
+	path     string
+	keyboard string
+	flags    []string
+	open     func(string) (LedReader, error)
+	reader   LedReader
+	events   chan LayerEvent
+}
+
+func NewLedSource(path string, keyboard string, flags []string) *LedSource {
+	return &LedSource{path: path, keyboard: keyboard, flags: flags, open: openLedReader}
+}
+
+// Open the LED device, and report a flag change for every LED change.
+func (source *LedSource) Start() error {
+
+	reader, err := source.open(source.path)
+
+	if err != nil {
+		return err
+	}
+
+	source.reader = reader
+	source.events = make(chan LayerEvent)
+
+	go func() {
+		defer close(source.events)
+
+		for {
+			leds, err := reader.ReadLeds()
+
+			if err != nil {
+				return
+			}
+
+			for _, flag := range source.flags {
+				if value, ok := leds.flagValue(flag); ok {
+					source.events <- LayerEvent{Kind: FlagChanged, Keyboard: source.keyboard, Flag: flag, Value: value}
+				}
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Close the device, which ends the read loop and closes the events channel.
+func (source *LedSource) Stop() error {
+	return source.reader.Close()
+}
+
+func (source *LedSource) Events() <-chan LayerEvent {
+	return source.events
+}
@@ -0,0 +1,108 @@
+//go:build linux
+
+package tray
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// The parts of the evdev interface needed to follow the LEDs of a keyboard.
+// See linux/input.h and linux/input-event-codes.h.
+const (
+	evSyn = 0x00
+	evLed = 0x11
+
+	ledNumLock    = 0x00
+	ledCapsLock   = 0x01
+	ledScrollLock = 0x02
+
+	// EVIOCGLED(2), which reads the bits of every LED, of which there are
+	// at most 16.
+	evdevGetLeds = 2<<30 | 2<<16 | 'E'<<8 | 0x19
+)
+
+// A single struct input_event, which starts with a timeval, so varies in size.
+var evdevEventSize = int(unsafe.Sizeof(syscall.Timeval{})) + 8
+
+// The LEDs of an evdev device, i.e. /dev/input/eventN.
+type evdevLeds struct {
+	file    io.ReadCloser
+	leds    LedState
+	started bool
+}
+
+// Open an evdev device, reading its current LEDs straight away.
+func openLedReader(path string) (LedReader, error) {
+
+	if path == "" {
+		return nil, errors.New("no led device configured")
+	}
+
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	bits := make([]byte, 2)
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), evdevGetLeds, uintptr(unsafe.Pointer(&bits[0])))
+
+	if errno != 0 {
+		file.Close()
+		return nil, errno
+	}
+
+	leds := LedState{
+		CapsLock:   bits[0]&(1<<ledCapsLock) != 0,
+		NumLock:    bits[0]&(1<<ledNumLock) != 0,
+		ScrollLock: bits[0]&(1<<ledScrollLock) != 0,
+	}
+
+	return &evdevLeds{file, leds, false}, nil
+}
+
+// Return the LEDs read on open, then wait for the next change. Changes only
+// count once the device sends a sync, since one change can cover several LEDs.
+func (device *evdevLeds) ReadLeds() (LedState, error) {
+
+	if !device.started {
+		device.started = true
+		return device.leds, nil
+	}
+
+	event := make([]byte, evdevEventSize)
+	current := device.leds
+
+	for {
+		_, err := io.ReadFull(device.file, event)
+
+		if err != nil {
+			return LedState{}, err
+		}
+
+		kind := binary.NativeEndian.Uint16(event[evdevEventSize-8:])
+		code := binary.NativeEndian.Uint16(event[evdevEventSize-6:])
+		on := binary.NativeEndian.Uint32(event[evdevEventSize-4:]) != 0
+
+		switch {
+		case kind == evLed && code == ledCapsLock:
+			current.CapsLock = on
+		case kind == evLed && code == ledNumLock:
+			current.NumLock = on
+		case kind == evLed && code == ledScrollLock:
+			current.ScrollLock = on
+		case kind == evSyn && current != device.leds:
+			device.leds = current
+			return current, nil
+		}
+	}
+}
+
+func (device *evdevLeds) Close() error {
+	return device.file.Close()
+}
@@ -0,0 +1,63 @@
+//go:build linux
+
+package tray
+
+import (
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// Build a single struct input_event.
+func evdevEvent(kind uint16, code uint16, value uint32) []byte {
+
+	event := make([]byte, evdevEventSize)
+	binary.NativeEndian.PutUint16(event[evdevEventSize-8:], kind)
+	binary.NativeEndian.PutUint16(event[evdevEventSize-6:], code)
+	binary.NativeEndian.PutUint32(event[evdevEventSize-4:], value)
+
+	return event
+}
+
+func TestEvdevLeds(t *testing.T) {
+
+	reader, writer := io.Pipe()
+	device := &evdevLeds{reader, LedState{NumLock: true}, false}
+
+	go func() {
+		for _, event := range [][]byte{
+			evdevEvent(evLed, ledCapsLock, 1),
+			evdevEvent(evSyn, 0, 0),
+			// A sync with nothing changed, and a key event, are skipped.
+			evdevEvent(evSyn, 0, 0),
+			evdevEvent(0x01, 30, 1),
+			evdevEvent(evSyn, 0, 0),
+			// Several LEDs can change in one report.
+			evdevEvent(evLed, ledNumLock, 0),
+			evdevEvent(evLed, ledScrollLock, 1),
+			evdevEvent(evSyn, 0, 0),
+		} {
+			writer.Write(event)
+		}
+
+		writer.Close()
+	}()
+
+	for i, want := range []LedState{
+		{NumLock: true},
+		{CapsLock: true, NumLock: true},
+		{CapsLock: true, ScrollLock: true},
+	} {
+		got, err := device.ReadLeds()
+
+		if err != nil {
+			t.Fatalf("read %d failed: %s", i, err)
+		} else if got != want {
+			t.Fatalf("read %d: got %+v, want %+v", i, got, want)
+		}
+	}
+
+	if _, err := device.ReadLeds(); err == nil {
+		t.Fatal("expected an error once the device is closed")
+	}
+}
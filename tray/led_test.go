@@ -0,0 +1,175 @@
+package tray
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+// A stream of LED states that the test feeds in, instead of a device.
+type fakeLeds struct {
+	states chan LedState
+}
+
+func (leds *fakeLeds) ReadLeds() (LedState, error) {
+
+	state, ok := <-leds.states
+	if !ok {
+		return LedState{}, io.EOF
+	}
+
+	return state, nil
+}
+
+func (leds *fakeLeds) Close() error {
+	close(leds.states)
+	return nil
+}
+
+const ledTestConfig = `{
+	"layers": ` + testLayers + `,
+	"ledDevice": "/dev/input/test-kbd",
+	"flags": [
+		{"name": "scroll-lock", "icon": {"text": "S"}},
+		{"name": "caps-word", "toggle": {"mods": "ctrl-shift", "key": "F9"}}
+	]
+}`
+
+// Wait for every LED flag to match the given LEDs.
+func waitForLeds(t *testing.T, state *TrayState, want LedState) {
+
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		got := LedState{}
+
+		state.loop.Do(func() {
+			keyboard := state.keyboards[0]
+			got = LedState{
+				keyboard.FindFlag(CapsLockFlag).value,
+				keyboard.FindFlag(NumLockFlag).value,
+				keyboard.FindFlag(ScrollLockFlag).value,
+			}
+		})
+
+		if got == want {
+			return
+		} else if time.Now().After(deadline) {
+			t.Fatalf("expected the flags to follow the LEDs %+v, got %+v", want, got)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestLedSourceSetsFlags(t *testing.T) {
+
+	state := newTestState(t, ledTestConfig)
+	leds := &fakeLeds{make(chan LedState)}
+
+	var source *LedSource
+	var err error
+
+	state.loop.Do(func() {
+		keyboard := state.keyboards[0]
+		source = NewLedSource(keyboard.config.LedDevice, keyboard.name, keyboard.ledFlags())
+		source.open = func(path string) (LedReader, error) {
+			if path != "/dev/input/test-kbd" {
+				return nil, errors.New("wrong device")
+			}
+
+			return leds, nil
+		}
+
+		err = state.RunSource(source)
+	})
+
+	if err != nil {
+		t.Fatalf("failed to start the led source: %s", err)
+	}
+
+	for _, want := range []LedState{
+		{CapsLock: true},
+		{CapsLock: true, NumLock: true, ScrollLock: true},
+		{NumLock: true},
+		{},
+	} {
+		leds.states <- want
+		waitForLeds(t, state, want)
+	}
+
+	source.Stop()
+
+	// The events channel closes once the reader has stopped.
+	select {
+	case _, open := <-source.Events():
+		if open {
+			t.Error("expected no more events after stopping")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the led source never stopped")
+	}
+
+	state.loop.Do(func() {
+		if caps := state.keyboards[0].FindFlag("caps-word"); caps == nil || caps.value {
+			t.Errorf("expected caps-word to be left alone, got %+v", caps)
+		}
+	})
+}
+
+func TestLedFlagsOnlyFollowLedDevice(t *testing.T) {
+
+	state := newTestState(t, ledTestConfig)
+
+	state.loop.Do(func() {
+		keyboard := state.keyboards[0]
+		want := []string{ScrollLockFlag, CapsLockFlag, NumLockFlag}
+
+		if got := keyboard.ledFlags(); len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+			t.Errorf("expected the led flags %v, got %v", want, got)
+		}
+	})
+
+	// Without a device, a caps-lock flag is just a normal flag.
+	state = newTestState(t, `{"layers": `+testLayers+`, "flags": [{"name": "caps-lock"}]}`)
+
+	state.loop.Do(func() {
+		keyboard := state.keyboards[0]
+
+		if got := keyboard.ledFlags(); len(got) != 0 {
+			t.Errorf("expected no led flags without a device, got %v", got)
+		}
+
+		if keyboard.FindFlag(NumLockFlag) != nil {
+			t.Error("expected no default num-lock flag without a device")
+		}
+	})
+}
+
+// Clicking a flag that follows an LED would only put the two out of sync.
+func TestLedFlagMenuItemsDisabled(t *testing.T) {
+
+	state := newTestState(t, ledTestConfig)
+	var source *MenuSource
+
+	state.loop.Do(func() {
+		source = NewMenuSource(state, state.keyboards[0])
+		source.Start()
+	})
+
+	defer source.Stop()
+
+	state.loop.Do(func() {
+		for _, flag := range state.keyboards[0].flags {
+			want := flag.name == CapsLockFlag || flag.name == NumLockFlag || flag.name == ScrollLockFlag
+
+			if flag.item == nil {
+				t.Errorf("%s has no menu item", flag.name)
+			} else if flag.item.Disabled() != want {
+				t.Errorf("%s: expected disabled %t, got %t", flag.name, want, flag.item.Disabled())
+			}
+		}
+	})
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package tray
+
+import "errors"
+
+// Keyboard LEDs are only read through evdev for now.
+func openLedReader(path string) (LedReader, error) {
+	return nil, errors.New("keyboard leds are only supported on linux")
+}
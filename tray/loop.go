@@ -0,0 +1,99 @@
+package tray
+
+// How many actions can queue up on the event loop, before anything queueing
+// more has to wait for it to catch up.
+const eventLoopBuffer = 64
+
+// Every change to the tray state runs on a single goroutine, so the layer
+// sources, control socket, theme and config watchers never race each other.
+// Actions run one at a time, in the order they were queued, so a burst of
+// events from a source is always applied in the order it was sent.
+type EventLoop struct {
+	actions chan func()
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// Make a new event loop, which is running straight away.
+func NewEventLoop() *EventLoop {
+
+	loop := &EventLoop{
+		make(chan func(), eventLoopBuffer),
+		make(chan struct{}),
+		make(chan struct{}),
+	}
+
+	go loop.run()
+
+	return loop
+}
+
+func (loop *EventLoop) run() {
+
+	defer close(loop.stopped)
+
+	for {
+		select {
+		case action := <-loop.actions:
+			action()
+		case <-loop.done:
+			return
+		}
+	}
+}
+
+// Queue an action to run on the loop, without waiting for it to run.
+// Returns false if the loop has stopped, so the action will never run.
+func (loop *EventLoop) Post(action func()) bool {
+
+	select {
+	case <-loop.done:
+		return false
+	default:
+	}
+
+	select {
+	case loop.actions <- action:
+		return true
+	case <-loop.done:
+		return false
+	}
+}
+
+// Run an action on the loop, and wait for it to finish.
+// Returns false if the loop stopped before the action could run.
+// This must never be called from the loop itself, since it would wait forever.
+func (loop *EventLoop) Do(action func()) bool {
+
+	finished := make(chan struct{})
+
+	queued := loop.Post(func() {
+		action()
+		close(finished)
+	})
+
+	if !queued {
+		return false
+	}
+
+	select {
+	case <-finished:
+		return true
+	case <-loop.stopped:
+		// The action may have been the last thing the loop ran.
+		select {
+		case <-finished:
+			return true
+		default:
+			return false
+		}
+	}
+}
+
+// Stop the loop, once the current action has finished. Anything still queued
+// is dropped. Like Do, this must never be called from the loop itself.
+func (loop *EventLoop) Stop() {
+
+	close(loop.done)
+	<-loop.stopped
+}
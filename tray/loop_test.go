@@ -0,0 +1,189 @@
+package tray
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+func TestEventLoopRunsActionsInOrder(t *testing.T) {
+
+	loop := NewEventLoop()
+	defer loop.Stop()
+
+	got := []int{}
+
+	for i := 0; i < 1000; i++ {
+		i := i
+		if !loop.Post(func() { got = append(got, i) }) {
+			t.Fatalf("post %d failed on a running loop", i)
+		}
+	}
+
+	if !loop.Do(func() {}) {
+		t.Fatal("do failed on a running loop")
+	}
+
+	for i, value := range got {
+		if value != i {
+			t.Fatalf("action %d ran as action %d", value, i)
+		}
+	}
+
+	if len(got) != 1000 {
+		t.Fatalf("expected 1000 actions to run, got %d", len(got))
+	}
+}
+
+func TestEventLoopDoWaitsForAction(t *testing.T) {
+
+	loop := NewEventLoop()
+	defer loop.Stop()
+
+	ran := false
+
+	loop.Do(func() {
+		time.Sleep(10 * time.Millisecond)
+		ran = true
+	})
+
+	if !ran {
+		t.Fatal("do returned before the action finished")
+	}
+}
+
+func TestEventLoopAfterStop(t *testing.T) {
+
+	loop := NewEventLoop()
+	loop.Stop()
+
+	ran := false
+
+	if loop.Post(func() { ran = true }) {
+		t.Error("post succeeded on a stopped loop")
+	}
+
+	if loop.Do(func() { ran = true }) {
+		t.Error("do succeeded on a stopped loop")
+	}
+
+	if ran {
+		t.Error("an action ran on a stopped loop")
+	}
+}
+
+func TestEventLoopStopWhileWaiting(t *testing.T) {
+
+	loop := NewEventLoop()
+	blocked := make(chan struct{})
+	release := make(chan struct{})
+
+	loop.Post(func() {
+		close(blocked)
+		<-release
+	})
+
+	<-blocked
+
+	// Anything waiting on the loop must return once it stops, whether or not
+	// its action ever ran.
+	var waiting sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		waiting.Add(1)
+
+		go func() {
+			defer waiting.Done()
+			loop.Do(func() {})
+		}()
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		loop.Stop()
+		close(stopped)
+	}()
+
+	close(release)
+	<-stopped
+
+	done := make(chan struct{})
+	go func() {
+		waiting.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("do never returned after the loop stopped")
+	}
+}
+
+func TestConcurrentSourcesApplyInOrder(t *testing.T) {
+
+	state := newTestState(t, `{
+		"layers": `+testLayers+`,
+		"flags": [{"name": "caps-word"}, {"name": "mouse"}]
+	}`)
+
+	// Each source ends on a different value, which is only what is left if
+	// its events were applied in the order it sent them.
+	layers, capsWord, mouse := []LayerEvent{}, []LayerEvent{}, []LayerEvent{}
+
+	for i := 0; i < 199; i++ {
+		layers = append(layers, LayerEvent{Kind: LayerChanged, LayerName: []string{"Num", "Base", "Nav"}[i%3]})
+		capsWord = append(capsWord, LayerEvent{Kind: FlagToggled, Flag: "caps-word"})
+		mouse = append(mouse, LayerEvent{Kind: FlagChanged, Flag: "mouse", Value: i%2 == 0})
+	}
+
+	sources := []*fakeSource{newFakeSource(layers...), newFakeSource(capsWord...), newFakeSource(mouse...)}
+
+	for _, source := range sources {
+		if err := state.RunSource(source); err != nil {
+			t.Fatalf("failed to start source: %s", err)
+		}
+	}
+
+	// Read the state from outside the loop too, as the control socket does.
+	var readers sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+
+		go func() {
+			defer readers.Done()
+
+			for j := 0; j < 50; j++ {
+				state.loop.Do(func() { state.CurrentState() })
+			}
+		}()
+	}
+
+	readers.Wait()
+
+	for _, source := range sources {
+		select {
+		case <-source.drained:
+		case <-time.After(5 * time.Second):
+			t.Fatal("source was never drained")
+		}
+	}
+
+	// Every event is queued by now, so this runs after all of them.
+	var current SaveState
+	state.loop.Do(func() { current = state.CurrentState() })
+
+	if current.LayerName != "Num" || !slices.Equal(current.Stack, []string{"Num"}) {
+		t.Errorf("expected only Num on the stack, got %s with %v", current.LayerName, current.Stack)
+	}
+
+	want := map[string]bool{"caps-word": true, "mouse": true}
+
+	if !maps.Equal(current.Flags, want) {
+		t.Errorf("expected flags %v, got %v", want, current.Flags)
+	}
+}
@@ -0,0 +1,194 @@
+package tray
+
+import (
+	"sync"
+
+	"github.com/getlantern/systray"
+	"golang.org/x/exp/slices"
+)
+
+// The "Layers" and "Flags" menus as a layer source, with one item per layer
+// and flag of a keyboard. Clicking an item swaps to that layer or flips that
+// flag, exactly as its hotkey would.
+type MenuSource struct {
+	state    *TrayState
+	keyboard *Keyboard
+	items    map[menuSlot][]*systray.MenuItem
+	events   chan LayerEvent
+	done     chan struct{}
+	running  sync.WaitGroup
+}
+
+// Where in the menu an item is, so it can be reused. Menu items can't be
+// deleted, so the items of a stopped source are hidden, then shown again in
+// the same order by the next source of the same keyboard, i.e. after a reload.
+type menuSlot struct {
+	keyboard string
+	parent   *systray.MenuItem
+	checkbox bool
+}
+
+func NewMenuSource(state *TrayState, keyboard *Keyboard) *MenuSource {
+	return &MenuSource{state: state, keyboard: keyboard}
+}
+
+// Add an item for each layer to the menu, and listen for clicks on them.
+func (source *MenuSource) Start() error {
+
+	state := source.state
+	keyboard := source.keyboard
+	source.events = make(chan LayerEvent)
+	source.done = make(chan struct{})
+	source.items = map[menuSlot][]*systray.MenuItem{}
+	keyboard.layer_items = map[int]*systray.MenuItem{}
+
+	// With more than one keyboard, head each keyboard's layers with its name.
+	if len(state.keyboards) > 1 {
+		header := source.addItem(state.tray.layers, false, keyboard.name, "", false)
+		header.Disable()
+	}
+
+	// The tray keeps every checkbox in sync with the state, so they are only
+	// ever checked or unchecked from the loop.
+	for _, keybind := range keyboard.keybinds {
+
+		checked := slices.Contains(keyboard.layer_stack, keybind.id)
+		item := source.addItem(state.tray.layers, true, keybind.name, "Swap to this layer", checked)
+		event := LayerEvent{Kind: LayerChanged, LayerId: keybind.id, LayerName: keybind.name, Keyboard: keyboard.name}
+
+		keyboard.layer_items[keybind.id] = item
+		source.listen(item, event)
+	}
+
+	// Every flag gets a checkbox to flip it. Flags that follow an LED can only
+	// be changed by the LED, so their items just show it.
+	for _, flag := range keyboard.flags {
+
+		item := source.addItem(state.tray.flags, true, keyboard.label(flag.name), "Turn this flag on or off", flag.value)
+		event := LayerEvent{Kind: FlagToggled, Keyboard: keyboard.name, Flag: flag.name}
+
+		flag.item = item
+
+		if keyboard.followsLed(flag) {
+			item.SetTooltip("Follows the keyboard LED")
+			item.Disable()
+			continue
+		}
+
+		source.listen(item, event)
+	}
+
+	// Every output gets an item too, to correct it if it gets out of sync.
+	for _, output := range keyboard.outputs {
+
+		item := source.addItem(state.tray.output, true, keyboard.label(output.name), "Swap to this output", output.name == keyboard.output)
+		event := LayerEvent{Kind: OutputChanged, Keyboard: keyboard.name, Output: output.name}
+
+		output.item = item
+		source.listen(item, event)
+	}
+
+	return nil
+}
+
+// Add an item to a menu, reusing a hidden one from a stopped source if there
+// is one in the same place.
+func (source *MenuSource) addItem(parent *systray.MenuItem, checkbox bool, title string, tooltip string, checked bool) *systray.MenuItem {
+
+	slot := menuSlot{source.keyboard.name, parent, checkbox}
+	spare := source.state.tray.spare
+	var item *systray.MenuItem
+
+	if len(spare[slot]) != 0 {
+		item = spare[slot][0]
+		spare[slot] = spare[slot][1:]
+
+		item.SetTitle(title)
+		item.SetTooltip(tooltip)
+		item.Enable()
+		item.Show()
+	} else if checkbox {
+		item = parent.AddSubMenuItemCheckbox(title, tooltip, checked)
+	} else {
+		item = parent.AddSubMenuItem(title, tooltip)
+	}
+
+	if checkbox && checked {
+		item.Check()
+	} else if checkbox {
+		item.Uncheck()
+	}
+
+	source.items[slot] = append(source.items[slot], item)
+
+	return item
+}
+
+// Send the given event every time the item is clicked, until the source is
+// stopped.
+func (source *MenuSource) listen(item *systray.MenuItem, event LayerEvent) {
+
+	source.running.Add(1)
+
+	go func() {
+		defer source.running.Done()
+
+		for {
+			select {
+			case <-item.ClickedCh:
+			case <-source.done:
+				return
+			}
+
+			select {
+			case source.events <- event:
+			case <-source.done:
+				return
+			}
+
+			// Clicking a checkbox can flip it, even if nothing changes, i.e.
+			// for the current layer, so have the tray put it back. This is
+			// queued separately, since Stop waits on this from the loop.
+			state := source.state
+			go state.loop.Post(state.RefreshTray)
+		}
+	}()
+}
+
+// Remove the layer items from the menu, then stop sending events.
+// Menu items can't be deleted, so they are hidden and kept to be reused, in
+// front of any left over from before so they stay in menu order.
+func (source *MenuSource) Stop() error {
+
+	close(source.done)
+
+	spare := source.state.tray.spare
+
+	for slot, items := range source.items {
+		for _, item := range items {
+			item.Hide()
+		}
+
+		spare[slot] = append(items, spare[slot]...)
+	}
+
+	source.items = nil
+	source.keyboard.layer_items = nil
+
+	for _, flag := range source.keyboard.flags {
+		flag.item = nil
+	}
+
+	for _, output := range source.keyboard.outputs {
+		output.item = nil
+	}
+
+	source.running.Wait()
+	close(source.events)
+
+	return nil
+}
+
+func (source *MenuSource) Events() <-chan LayerEvent {
+	return source.events
+}
@@ -0,0 +1,121 @@
+package tray
+
+import (
+	"testing"
+	"time"
+
+	"github.com/getlantern/systray"
+)
+
+// Wait for a check on the tray state to pass, running it on the loop.
+func waitForState(t *testing.T, state *TrayState, what string, check func() bool) {
+
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		var ok bool
+		state.loop.Do(func() { ok = check() })
+
+		if ok {
+			return
+		} else if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMenuClicks(t *testing.T) {
+
+	state := newTestState(t, `{
+		"layers": `+testLayers+`,
+		"flags": [{"name": "mouse"}],
+		"outputs": [{"name": "USB"}, {"name": "BT"}]
+	}`)
+
+	keyboard := state.keyboards[0]
+	source := NewMenuSource(state, keyboard)
+
+	state.loop.Do(func() { state.RunSource(source) })
+	defer state.loop.Do(func() { source.Stop() })
+
+	var base, nav, mouse, bt *systray.MenuItem
+	state.loop.Do(func() {
+		base, nav = keyboard.layer_items[0], keyboard.layer_items[1]
+		mouse, bt = keyboard.FindFlag("mouse").item, keyboard.FindOutput("BT").item
+	})
+
+	nav.ClickedCh <- struct{}{}
+	waitForState(t, state, "Nav to be checked", func() bool {
+		return keyboard.layer_name == "Nav" && nav.Checked() && !base.Checked()
+	})
+
+	// Clicking the current layer can uncheck it, which the tray puts back.
+	state.loop.Do(nav.Uncheck)
+	nav.ClickedCh <- struct{}{}
+	waitForState(t, state, "Nav to be checked again", func() bool {
+		return nav.Checked()
+	})
+
+	mouse.ClickedCh <- struct{}{}
+	waitForState(t, state, "mouse to be on", func() bool {
+		return keyboard.FindFlag("mouse").value && mouse.Checked()
+	})
+
+	mouse.ClickedCh <- struct{}{}
+	waitForState(t, state, "mouse to be off", func() bool {
+		return !keyboard.FindFlag("mouse").value && !mouse.Checked()
+	})
+
+	bt.ClickedCh <- struct{}{}
+	waitForState(t, state, "BT to be checked", func() bool {
+		return keyboard.output == "BT" && bt.Checked() && !keyboard.FindOutput("USB").item.Checked()
+	})
+}
+
+func TestMenuItemsAreReused(t *testing.T) {
+
+	state := newTestState(t, `{"layers": `+testLayers+`, "flags": [{"name": "mouse"}]}`)
+
+	items := func() []*systray.MenuItem {
+		keyboard := state.keyboards[0]
+		items := []*systray.MenuItem{}
+
+		for _, keybind := range keyboard.keybinds {
+			items = append(items, keyboard.layer_items[keybind.id])
+		}
+
+		return append(items, keyboard.FindFlag("mouse").item)
+	}
+
+	defer state.loop.Do(state.StopSources)
+
+	var first, second []*systray.MenuItem
+
+	state.loop.Do(func() {
+		state.StartSources()
+		first = items()
+	})
+
+	// Drop a layer, which leaves its item hidden, then bring it back.
+	writeTestConfig(t, `{"layers": [{"name": "Base", "mods": "ctrl-shift", "key": "F1"}], "flags": [{"name": "mouse"}]}`)
+	state.loop.Do(func() { state.ReloadConfiguration() })
+
+	writeTestConfig(t, `{"layers": `+testLayers+`, "flags": [{"name": "mouse"}]}`)
+	state.loop.Do(func() {
+		state.ReloadConfiguration()
+		second = items()
+	})
+
+	if len(first) != len(second) {
+		t.Fatalf("expected %d items, got %d", len(first), len(second))
+	}
+
+	for i := range first {
+		if first[i] != second[i] {
+			t.Errorf("expected item %d to be reused, got a new one", i)
+		}
+	}
+}
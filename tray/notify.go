@@ -0,0 +1,152 @@
+package tray
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/adrg/xdg"
+	"golang.org/x/exp/slices"
+)
+
+// How urgent a notification is, matching the freedesktop urgency levels.
+type Urgency byte
+
+const (
+	UrgencyLow Urgency = iota
+	UrgencyNormal
+	UrgencyCritical
+)
+
+var urgencyNames = []string{"low", "normal", "critical"}
+
+// A single desktop notification. If ReplacesId is set, the notification with
+// that id is updated in place, rather than a new one being shown.
+type Notification struct {
+	Summary    string
+	Body       string
+	Icon       string
+	Urgency    Urgency
+	ReplacesId uint32
+}
+
+// Something that can show desktop notifications, i.e. the freedesktop
+// notification daemon on Linux. Notify returns the id of the notification
+// it showed, so it can be replaced later.
+type Notifier interface {
+	Notify(notification Notification) (uint32, error)
+	Close() error
+}
+
+// Parse an urgency setting, defaulting to normal when it is not set.
+func ParseUrgency(name string) (Urgency, error) {
+
+	if name == "" {
+		return UrgencyNormal, nil
+	}
+
+	i := slices.Index(urgencyNames, strings.ToLower(name))
+
+	if i == -1 {
+		return UrgencyNormal, fmt.Errorf("unknown urgency %q, should be one of %s", name, strings.Join(urgencyNames, ", "))
+	}
+
+	return Urgency(i), nil
+}
+
+// Show a notification for a layer that was just entered, if it asked for them.
+func (keyboard *Keyboard) notifyLayer(keybind *Keybinding) {
+
+	config := keyboard.layerConfig(keybind)
+
+	if config == nil || !config.Notify {
+		return
+	}
+
+	keyboard.state.showNotification(keyboard.label(fmt.Sprintf("%s Layer", keybind.name)), "", keyboard.icon(), config.Urgency)
+}
+
+// Show a notification for the new connection state, if the config asked for them.
+func (keyboard *Keyboard) notifyConnect() {
+
+	if !keyboard.config.NotifyConnect {
+		return
+	}
+
+	keybind := keyboard.FindLayer(keyboard.layer_id, "")
+	urgency := ""
+
+	if config := keyboard.layerConfig(keybind); config != nil {
+		urgency = config.Urgency
+	}
+
+	summary := "Disconnected"
+	if keyboard.connected() {
+		summary = "Connected"
+	}
+
+	body := keyboard.title()
+	if len(keyboard.groups) < 2 {
+		body = fmt.Sprintf("%s Layer", body)
+	}
+
+	keyboard.state.showNotification(keyboard.label(summary), body, keyboard.icon(), urgency)
+}
+
+// Show a notification, replacing the last one so they never stack up.
+// The notification daemon can be slow to answer, so notifications are shown
+// in the background, in order. The last id is only kept by that queue, since
+// the next notification may be queued before the last one is shown.
+func (state *TrayState) showNotification(summary string, body string, icon []byte, urgencyName string) {
+
+	notifier := state.notifier
+
+	if notifier == nil {
+		return
+	}
+
+	urgency, err := ParseUrgency(urgencyName)
+	if err != nil {
+		state.logger.Printf("Invalid notification urgency: %s\n", err.Error())
+	}
+
+	state.notifications.add(func() {
+		iconPath := ""
+		if len(icon) != 0 {
+			iconPath = state.iconFile(icon)
+		}
+
+		id, err := notifier.Notify(Notification{summary, body, iconPath, urgency, state.notification_id})
+
+		if err != nil {
+			state.logger.Printf("Failed to show notification: %s\n", err.Error())
+			return
+		}
+
+		state.notification_id = id
+	})
+}
+
+// Notifications need an icon on disk, but the layer icons may be built in, so
+// save a copy of the icon in the cache, named by its contents.
+func (state *TrayState) iconFile(icon []byte) string {
+
+	path, err := xdg.CacheFile(fmt.Sprintf("kb_ui/icons/%x.%s", sha1.Sum(icon), DetectIconFormat(icon)))
+
+	if err != nil {
+		state.logger.Printf("Failed to find icon cache: %s\n", err.Error())
+		return ""
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		return path
+	}
+
+	if err := os.WriteFile(path, icon, 0644); err != nil {
+		state.logger.Printf("Failed to cache icon: %s\n", err.Error())
+		return ""
+	}
+
+	return path
+}
@@ -0,0 +1,11 @@
+//go:build darwin
+
+package tray
+
+import "errors"
+
+// Only the freedesktop notification daemon is supported for now, so no
+// notifications are shown here.
+func NewNotifier() (Notifier, error) {
+	return nil, errors.New("notifications are only supported on linux")
+}
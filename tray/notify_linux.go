@@ -0,0 +1,78 @@
+//go:build linux
+
+package tray
+
+import (
+	"context"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	notificationsName    = "org.freedesktop.Notifications"
+	notificationsPath    = "/org/freedesktop/Notifications"
+	notificationsTimeout = 2 * time.Second
+)
+
+// Notifications, sent to the freedesktop notification daemon.
+type dbusNotifier struct {
+	conn *dbus.Conn
+}
+
+func NewNotifier() (Notifier, error) {
+
+	conn, err := dbus.SessionBusPrivate()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = conn.Auth(nil); err == nil {
+		err = conn.Hello()
+	}
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return NewDbusNotifier(conn), nil
+}
+
+// Send notifications over an existing bus connection, i.e. a private bus with
+// a fake notification daemon on it. The notifier takes over the connection.
+func NewDbusNotifier(conn *dbus.Conn) Notifier {
+	return &dbusNotifier{conn}
+}
+
+func (notifier *dbusNotifier) Notify(notification Notification) (uint32, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), notificationsTimeout)
+	defer cancel()
+
+	hints := map[string]dbus.Variant{
+		"urgency": dbus.MakeVariant(byte(notification.Urgency)),
+	}
+
+	id := uint32(0)
+	err := notifier.conn.Object(notificationsName, notificationsPath).CallWithContext(
+		ctx,
+		notificationsName+".Notify",
+		0,
+		"kb_ui",
+		notification.ReplacesId,
+		notification.Icon,
+		notification.Summary,
+		notification.Body,
+		[]string{},
+		hints,
+		int32(-1),
+	).Store(&id)
+
+	return id, err
+}
+
+func (notifier *dbusNotifier) Close() error {
+	return notifier.conn.Close()
+}
@@ -0,0 +1,127 @@
+//go:build linux
+
+package tray
+
+import (
+	"bufio"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// A notification daemon that records every notification sent to it.
+type fakeDaemon struct {
+	lock   sync.Mutex
+	calls  []Notification
+	hints  []map[string]dbus.Variant
+	nextId uint32
+}
+
+func (daemon *fakeDaemon) Notify(app string, replacesId uint32, icon string, summary string, body string, actions []string, hints map[string]dbus.Variant, timeout int32) (uint32, *dbus.Error) {
+
+	daemon.lock.Lock()
+	defer daemon.lock.Unlock()
+
+	daemon.calls = append(daemon.calls, Notification{summary, body, icon, 0, replacesId})
+	daemon.hints = append(daemon.hints, hints)
+
+	if replacesId != 0 {
+		return replacesId, nil
+	}
+
+	daemon.nextId++
+	return daemon.nextId, nil
+}
+
+// Start a private session bus, skipping the test if there is no dbus-daemon.
+func startTestBus(t *testing.T) string {
+
+	t.Helper()
+
+	if _, err := exec.LookPath("dbus-daemon"); err != nil {
+		t.Skip("dbus-daemon is not installed")
+	}
+
+	cmd := exec.Command("dbus-daemon", "--session", "--nofork", "--print-address")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("failed to read the bus address: %s", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		t.Skipf("failed to start dbus-daemon: %s", err)
+	}
+
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	address, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Skipf("dbus-daemon gave no address: %s", err)
+	}
+
+	return strings.TrimSpace(address)
+}
+
+func TestDbusNotifier(t *testing.T) {
+
+	address := startTestBus(t)
+
+	daemonConn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatalf("failed to connect the daemon: %s", err)
+	}
+	defer daemonConn.Close()
+
+	daemon := &fakeDaemon{}
+	daemonConn.Export(daemon, notificationsPath, notificationsName)
+
+	if reply, err := daemonConn.RequestName(notificationsName, dbus.NameFlagDoNotQueue); err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("failed to own %s: %v", notificationsName, err)
+	}
+
+	clientConn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatalf("failed to connect the client: %s", err)
+	}
+
+	notifier := NewDbusNotifier(clientConn)
+	defer notifier.Close()
+
+	id, err := notifier.Notify(Notification{"Nav Layer", "", "/tmp/nav.png", UrgencyCritical, 0})
+	if err != nil || id != 1 {
+		t.Fatalf("expected a new notification with id 1, got %d, %v", id, err)
+	}
+
+	replaced, err := notifier.Notify(Notification{"Num Layer", "body", "", UrgencyLow, id})
+	if err != nil || replaced != id {
+		t.Fatalf("expected notification %d to be replaced, got %d, %v", id, replaced, err)
+	}
+
+	daemon.lock.Lock()
+	defer daemon.lock.Unlock()
+
+	want := []Notification{
+		{"Nav Layer", "", "/tmp/nav.png", 0, 0},
+		{"Num Layer", "body", "", 0, 1},
+	}
+
+	if len(daemon.calls) != len(want) {
+		t.Fatalf("expected %d calls, got %+v", len(want), daemon.calls)
+	}
+
+	for i, urgency := range []Urgency{UrgencyCritical, UrgencyLow} {
+		if daemon.calls[i] != want[i] {
+			t.Errorf("call %d: got %+v, want %+v", i, daemon.calls[i], want[i])
+		}
+
+		if got := daemon.hints[i]["urgency"].Value(); got != byte(urgency) {
+			t.Errorf("call %d: expected urgency %d, got %v", i, urgency, got)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package tray
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+// A notifier that records every notification, instead of showing it. With a
+// blocked channel, each notification waits on it, like a slow daemon.
+type fakeNotifier struct {
+	sent    []Notification
+	nextId  uint32
+	err     error
+	blocked chan struct{}
+}
+
+func (notifier *fakeNotifier) Notify(notification Notification) (uint32, error) {
+
+	if notifier.blocked != nil {
+		<-notifier.blocked
+	}
+
+	notifier.sent = append(notifier.sent, notification)
+
+	if notifier.err != nil {
+		return 0, notifier.err
+	}
+
+	notifier.nextId++
+
+	// Like the daemon, a replaced notification keeps its id.
+	if notification.ReplacesId != 0 {
+		return notification.ReplacesId, nil
+	}
+
+	return notifier.nextId + 100, nil
+}
+
+func (notifier *fakeNotifier) Close() error {
+	return nil
+}
+
+// Wait for every queued notification to be shown.
+func waitForNotifications(state *TrayState) {
+
+	done := make(chan struct{})
+	state.notifications.add(func() { close(done) })
+	<-done
+}
+
+func TestParseUrgency(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		want  Urgency
+		fails bool
+	}{
+		{"", UrgencyNormal, false},
+		{"low", UrgencyLow, false},
+		{"Normal", UrgencyNormal, false},
+		{"CRITICAL", UrgencyCritical, false},
+		{"urgent", UrgencyNormal, true},
+	}
+
+	for _, test := range tests {
+		got, err := ParseUrgency(test.name)
+
+		if (err != nil) != test.fails || got != test.want {
+			t.Errorf("ParseUrgency(%q) = %d, %v, want %d (fails %t)", test.name, got, err, test.want, test.fails)
+		}
+	}
+}
+
+func TestNotificationsReplaceEachOther(t *testing.T) {
+
+	state := newTestState(t, `{
+		"layers": [
+			{"name": "Base", "mods": "ctrl-shift", "key": "F1", "icon": {"text": "B"}},
+			{"name": "Nav", "mods": "ctrl-shift", "key": "F2", "icon": {"text": "N"}, "notify": true, "urgency": "critical"},
+			{"name": "Num", "mods": "ctrl-shift", "key": "F3", "icon": {"text": "1"}, "notify": true},
+			{"name": "Quiet", "mods": "ctrl-shift", "key": "F4", "icon": {"text": "Q"}}
+		],
+		"notifyConnect": true
+	}`)
+
+	notifier := &fakeNotifier{}
+
+	state.loop.Do(func() {
+		state.notifier = notifier
+
+		state.HandleEvent(LayerEvent{Kind: LayerChanged, LayerName: "Nav"})
+		state.HandleEvent(LayerEvent{Kind: LayerChanged, LayerName: "Quiet"})
+		state.HandleEvent(LayerEvent{Kind: LayerChanged, LayerName: "Num"})
+		state.HandleEvent(LayerEvent{Kind: ConnectToggled})
+	})
+
+	waitForNotifications(state)
+
+	want := []struct {
+		summary    string
+		urgency    Urgency
+		replacesId uint32
+	}{
+		{"Nav Layer", UrgencyCritical, 0},
+		{"Num Layer", UrgencyNormal, 101},
+		{"Disconnected", UrgencyNormal, 101},
+	}
+
+	if len(notifier.sent) != len(want) {
+		t.Fatalf("expected %d notifications, got %+v", len(want), notifier.sent)
+	}
+
+	for i, notification := range notifier.sent {
+		if notification.Summary != want[i].summary || notification.Urgency != want[i].urgency || notification.ReplacesId != want[i].replacesId {
+			t.Errorf("notification %d: got %+v, want %+v", i, notification, want[i])
+		}
+
+		if _, err := os.Stat(notification.Icon); err != nil {
+			t.Errorf("notification %d: icon %q is not on disk: %s", i, notification.Icon, err)
+		}
+	}
+
+	if body := notifier.sent[2].Body; body != "Num Layer" {
+		t.Errorf("expected the connect notification to name the layer, got %q", body)
+	}
+}
+
+// A failed notification leaves the last id alone, so the next one still
+// replaces whatever is on screen.
+func TestNotificationFailureKeepsId(t *testing.T) {
+
+	state := newTestState(t, `{"layers": `+testLayers+`, "notifyConnect": true}`)
+	notifier := &fakeNotifier{}
+
+	toggle := func() {
+		state.loop.Do(func() { state.HandleEvent(LayerEvent{Kind: ConnectToggled}) })
+		waitForNotifications(state)
+	}
+
+	state.loop.Do(func() { state.notifier = notifier })
+	toggle()
+
+	notifier.err = errors.New("no daemon")
+	toggle()
+
+	notifier.err = nil
+	toggle()
+
+	if len(notifier.sent) != 3 {
+		t.Fatalf("expected 3 notifications, got %+v", notifier.sent)
+	}
+
+	if got := notifier.sent[2].ReplacesId; got != 101 {
+		t.Errorf("expected the last notification to replace 101, got %d", got)
+	}
+}
+
+// A slow notification daemon never holds up the loop, and the notifications
+// still replace each other once it catches up.
+func TestSlowNotificationsDoNotBlock(t *testing.T) {
+
+	state := newTestState(t, `{"layers": `+testLayers+`, "notifyConnect": true}`)
+	notifier := &fakeNotifier{blocked: make(chan struct{})}
+
+	start := time.Now()
+
+	state.loop.Do(func() {
+		state.notifier = notifier
+
+		for i := 0; i < 3; i++ {
+			state.HandleEvent(LayerEvent{Kind: ConnectToggled})
+		}
+	})
+
+	var connected bool
+	state.loop.Do(func() { connected = state.keyboards[0].connected() })
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("the loop waited %s on the notifications", elapsed)
+	} else if connected {
+		t.Errorf("expected to be disconnected after three toggles")
+	}
+
+	close(notifier.blocked)
+	waitForNotifications(state)
+
+	if len(notifier.sent) != 3 {
+		t.Fatalf("expected 3 notifications, got %+v", notifier.sent)
+	}
+
+	for i, want := range []uint32{0, 101, 101} {
+		if got := notifier.sent[i].ReplacesId; got != want {
+			t.Errorf("notification %d: expected to replace %d, got %d", i, want, got)
+		}
+	}
+}
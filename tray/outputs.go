@@ -0,0 +1,136 @@
+package tray
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/getlantern/systray"
+	"golang.org/x/exp/slices"
+)
+
+// A single output of a keyboard, i.e. USB or a Bluetooth profile.
+type Output struct {
+	name      string
+	config    OutputConfig
+	icon      *[]byte
+	dark_icon *[]byte
+	item      *systray.MenuItem
+}
+
+// Build the outputs of a keyboard.
+func MakeOutputs(state *TrayState, config *KeyboardConfig) []*Output {
+
+	outputs := []*Output{}
+
+	for _, outputConfig := range config.Outputs {
+		output := &Output{name: outputConfig.Name, config: outputConfig}
+		output.icon, output.dark_icon = loadFlagIcons(state, outputConfig.Icon)
+
+		outputs = append(outputs, output)
+	}
+
+	return outputs
+}
+
+// Find an output by name.
+func (keyboard *Keyboard) FindOutput(name string) *Output {
+
+	i := slices.IndexFunc(keyboard.outputs, func(output *Output) bool {
+		return output.name == name
+	})
+
+	if i == -1 {
+		return nil
+	}
+
+	return keyboard.outputs[i]
+}
+
+// Swap to typing into a new output.
+func (keyboard *Keyboard) SetOutput(output *Output) {
+
+	if keyboard.output == output.name {
+		return
+	}
+
+	keyboard.output = output.name
+
+	state := keyboard.state
+	state.RefreshTray()
+	state.notifyChange()
+
+	if output.config.Connected != nil {
+		if flag := keyboard.FindFlag(ConnectFlag); flag != nil {
+			keyboard.SetFlag(flag, *output.config.Connected)
+		}
+	}
+}
+
+// Get the icon of the active output, if any.
+func (keyboard *Keyboard) outputIcon() (icon []byte, replace bool) {
+
+	output := keyboard.FindOutput(keyboard.output)
+	if output == nil {
+		return nil, false
+	}
+
+	return *keyboard.pickIcon(output.icon, output.dark_icon), output.config.Replace
+}
+
+// Show which output every keyboard is typing into, in the menu and tooltip.
+// The output item is hidden if no keyboard has outputs set up.
+func (state *TrayState) refreshOutputs() {
+
+	if state.tray == nil || state.tray.output == nil {
+		return
+	}
+
+	for _, keyboard := range state.keyboards {
+		for _, item := range keyboard.outputs {
+			if item.item != nil && item.name == keyboard.output {
+				item.item.Check()
+			} else if item.item != nil {
+				item.item.Uncheck()
+			}
+		}
+	}
+
+	tooltip := fmt.Sprintf("Keyboard Status (%s)", GetVersion())
+	title := state.outputTitle()
+
+	if title == "" {
+		state.tray.output.Hide()
+		systray.SetTooltip(tooltip)
+		return
+	}
+
+	state.tray.output.SetTitle(title)
+	state.tray.output.Show()
+	systray.SetTooltip(fmt.Sprintf("%s\n%s", tooltip, title))
+}
+
+// Get the output every keyboard with outputs is typing into, i.e.
+// "Output: USB", or nothing if no keyboard has outputs set up.
+func (state *TrayState) outputTitle() string {
+
+	lines := []string{}
+
+	for _, keyboard := range state.keyboards {
+		if len(keyboard.outputs) == 0 {
+			continue
+		}
+
+		output := keyboard.output
+		if output == "" {
+			output = "unknown"
+		}
+
+		lines = append(lines, keyboard.label(output))
+	}
+
+	if len(lines) == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("Output: %s", strings.Join(lines, " · "))
+}
@@ -0,0 +1,134 @@
+package tray
+
+import (
+	"testing"
+)
+
+const testOutputs = `{
+	"layers": ` + testLayers + `,
+	"outputs": [
+		{"name": "USB", "mods": "ctrl-alt", "key": "F5", "connected": true},
+		{"name": "BT1: Laptop", "mods": "ctrl-alt", "key": "F6", "connected": false},
+		{"name": "BT2: Mac", "mods": "ctrl-alt", "key": "F7", "icon": {"text": "2"}, "replace": true}
+	]
+}`
+
+// Swapping output shows it in the title, and sets the connection if the
+// output says what it should be.
+func TestOutputChanged(t *testing.T) {
+
+	state := newTestState(t, testOutputs)
+
+	tests := []struct {
+		output    string
+		want      string
+		connected bool
+		replace   bool
+	}{
+		{"", "", true, false},
+		{"BT1: Laptop", "BT1: Laptop", false, false},
+		{"BT2: Mac", "BT2: Mac", false, true},
+		{"USB", "USB", true, false},
+		{"BT9", "USB", true, false},
+	}
+
+	for _, test := range tests {
+		var output, title string
+		var connected, replace bool
+		var saved SaveState
+
+		state.loop.Do(func() {
+			keyboard := state.keyboards[0]
+
+			if test.output != "" {
+				state.HandleEvent(LayerEvent{Kind: OutputChanged, Output: test.output})
+			}
+
+			output = keyboard.output
+			title = state.outputTitle()
+			connected = keyboard.connected()
+			_, replace = keyboard.outputIcon()
+			saved = keyboard.savedState()
+		})
+
+		wantTitle := "Output: " + test.want
+		if test.want == "" {
+			wantTitle = "Output: unknown"
+		}
+
+		if output != test.want || saved.Output != test.want || title != wantTitle {
+			t.Errorf("%q: expected output %q titled %q, got %q titled %q (saved %q)", test.output, test.want, wantTitle, output, title, saved.Output)
+		}
+
+		if connected != test.connected {
+			t.Errorf("%q: expected connected to be %t", test.output, test.connected)
+		}
+
+		if replace != test.replace {
+			t.Errorf("%q: expected the output icon replace to be %t", test.output, test.replace)
+		}
+	}
+}
+
+func TestOutputIsRestored(t *testing.T) {
+
+	tests := []struct {
+		saved string
+		want  string
+	}{
+		{"BT2: Mac", "BT2: Mac"},
+		{"BT5", ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		state := newTestState(t, testOutputs)
+		writeStateFile(t, `{"version": 1, "id": 0, "name": "Base", "is_connected": true, "output": "`+test.saved+`"}`)
+
+		var output string
+
+		state.loop.Do(func() {
+			state.LoadPreviousState()
+			output = state.keyboards[0].output
+		})
+
+		if output != test.want {
+			t.Errorf("%q: expected to restore %q, got %q", test.saved, test.want, output)
+		}
+	}
+}
+
+// Only keyboards with outputs are in the title, named if there is more than
+// one keyboard.
+func TestOutputTitle(t *testing.T) {
+
+	tests := []struct {
+		name   string
+		config string
+		want   string
+	}{
+		{"no outputs", `{"layers": ` + testLayers + `}`, ""},
+		{"one keyboard", `{"keyboards": [
+			{"name": "Sofle", "layers": ` + testLayers + `},
+			{"name": "Pad", "layers": ` + testLayers + `, "outputs": [{"name": "USB"}]}
+		]}`, "Output: Pad: unknown"},
+		{"both keyboards", `{"keyboards": [
+			{"name": "Sofle", "layers": ` + testLayers + `, "outputs": [{"name": "BT1"}]},
+			{"name": "Pad", "layers": ` + testLayers + `, "outputs": [{"name": "USB"}]}
+		]}`, "Output: Sofle: BT1 · Pad: unknown"},
+	}
+
+	for _, test := range tests {
+		state := newTestState(t, test.config)
+		var title string
+
+		state.loop.Do(func() {
+			state.HandleEvent(LayerEvent{Kind: OutputChanged, Output: "BT1"})
+			title = state.outputTitle()
+		})
+
+		if title != test.want {
+			t.Errorf("%s: expected %q, got %q", test.name, test.want, title)
+		}
+	}
+}
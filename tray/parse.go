@@ -11,69 +11,152 @@ import (
 	"golang.design/x/hotkey"
 )
 
+// Keys that every platform supports, by their canonical name.
+// Extra platform specific keys are in parse_keys_<os>.go.
+var commonKeys = map[string]hotkey.Key{
+	"0": hotkey.Key0, "1": hotkey.Key1, "2": hotkey.Key2, "3": hotkey.Key3,
+	"4": hotkey.Key4, "5": hotkey.Key5, "6": hotkey.Key6, "7": hotkey.Key7,
+	"8": hotkey.Key8, "9": hotkey.Key9,
+
+	"a": hotkey.KeyA, "b": hotkey.KeyB, "c": hotkey.KeyC, "d": hotkey.KeyD,
+	"e": hotkey.KeyE, "f": hotkey.KeyF, "g": hotkey.KeyG, "h": hotkey.KeyH,
+	"i": hotkey.KeyI, "j": hotkey.KeyJ, "k": hotkey.KeyK, "l": hotkey.KeyL,
+	"m": hotkey.KeyM, "n": hotkey.KeyN, "o": hotkey.KeyO, "p": hotkey.KeyP,
+	"q": hotkey.KeyQ, "r": hotkey.KeyR, "s": hotkey.KeyS, "t": hotkey.KeyT,
+	"u": hotkey.KeyU, "v": hotkey.KeyV, "w": hotkey.KeyW, "x": hotkey.KeyX,
+	"y": hotkey.KeyY, "z": hotkey.KeyZ,
+
+	"f1": hotkey.KeyF1, "f2": hotkey.KeyF2, "f3": hotkey.KeyF3, "f4": hotkey.KeyF4,
+	"f5": hotkey.KeyF5, "f6": hotkey.KeyF6, "f7": hotkey.KeyF7, "f8": hotkey.KeyF8,
+	"f9": hotkey.KeyF9, "f10": hotkey.KeyF10, "f11": hotkey.KeyF11, "f12": hotkey.KeyF12,
+	"f13": hotkey.KeyF13, "f14": hotkey.KeyF14, "f15": hotkey.KeyF15, "f16": hotkey.KeyF16,
+	"f17": hotkey.KeyF17, "f18": hotkey.KeyF18, "f19": hotkey.KeyF19, "f20": hotkey.KeyF20,
+
+	"space":  hotkey.KeySpace,
+	"tab":    hotkey.KeyTab,
+	"escape": hotkey.KeyEscape,
+	"return": hotkey.KeyReturn,
+	"delete": hotkey.KeyDelete,
+	"left":   hotkey.KeyLeft,
+	"right":  hotkey.KeyRight,
+	"up":     hotkey.KeyUp,
+	"down":   hotkey.KeyDown,
+}
+
+// Other names that can be used for a key, mapped to its canonical name.
+var keyAliases = map[string]string{
+	"esc":       "escape",
+	"enter":     "return",
+	"ret":       "return",
+	"spacebar":  "space",
+	"del":       "delete",
+	"ins":       "insert",
+	"pgup":      "pageup",
+	"pgdn":      "pagedown",
+	"pgdown":    "pagedown",
+	"leftarrow": "left", "rightarrow": "right", "uparrow": "up", "downarrow": "down",
+	"numpadplus":  "numpadadd",
+	"numpadminus": "numpadsubtract",
+	"numpadstar":  "numpadmultiply",
+	"numpadslash": "numpaddivide",
+	"numpaddot":   "numpaddecimal",
+	"numpadenter": "numpadreturn",
+}
+
+// Get the canonical name for a key, i.e. "Page_Up" is "pageup", and "kp_1",
+// "num1" and "numpad 1" are all "numpad1".
+func canonicalKeyName(key string) string {
+
+	name := strings.ToLower(strings.TrimSpace(key))
+	name = strings.NewReplacer("_", "", "-", "", " ", "").Replace(name)
+
+	for _, prefix := range []string{"keypad", "kp", "num"} {
+		if strings.HasPrefix(name, prefix) && !strings.HasPrefix(name, "numpad") && len(name) > len(prefix) {
+			name = "numpad" + strings.TrimPrefix(name, prefix)
+			break
+		}
+	}
+
+	if alias, ok := keyAliases[name]; ok {
+		return alias
+	}
+
+	return name
+}
+
 func ParseKey(key string) (hotkey.Key, error) {
 
-	// TODO: Expand this to support more key names.
-	// This is good enough for now.
-	switch strings.ToLower(key) {
-	case "0":
-		return hotkey.Key0, nil
-	case "1":
-		return hotkey.Key1, nil
-	case "2":
-		return hotkey.Key2, nil
-	case "3":
-		return hotkey.Key3, nil
-	case "4":
-		return hotkey.Key4, nil
-	case "5":
-		return hotkey.Key5, nil
-	case "6":
-		return hotkey.Key6, nil
-	case "7":
-		return hotkey.Key7, nil
-	case "8":
-		return hotkey.Key8, nil
-	case "9":
-		return hotkey.Key9, nil
+	name := canonicalKeyName(key)
+
+	// Check the platform keys first, so they can override any common key
+	// that the hotkey library gets wrong.
+	if parsed, ok := platformKeys[name]; ok {
+		return parsed, nil
+	}
+
+	if parsed, ok := commonKeys[name]; ok {
+		return parsed, nil
 	}
 
 	return hotkey.KeyA, fmt.Errorf("unknown key: %s", key)
 }
 
-func loadIconFile(icon_path string) ([]byte, error) {
+// Find an icon file in the config folder, next to the config file. This is
+// used both to load and to validate icons, so they always agree.
+func iconFilePath(icon_path string) (string, error) {
 
 	full_path, err := xdg.ConfigFile(fmt.Sprintf("kb_ui/%s", icon_path))
 
 	if err != nil {
-		return icons.KB_Light_Data, err
+		return "", err
 	}
 
 	if _, err := os.Stat(full_path); errors.Is(err, os.ErrNotExist) {
-		return icons.KB_Light_Data, fmt.Errorf("icon file not found: %s", full_path)
+		return "", fmt.Errorf("icon file not found: %s", full_path)
+	}
+
+	return full_path, nil
+}
+
+// Load an icon file from the config folder, converting it to the format the
+// tray expects.
+func loadIconFile(icon_path string) ([]byte, error) {
+
+	full_path, err := iconFilePath(icon_path)
+
+	if err != nil {
+		return nil, err
 	}
 
 	file, err := os.ReadFile(full_path)
 
 	if err != nil {
-		return icons.KB_Light_Data, err
+		return nil, err
+	}
+
+	icon, err := ConvertIcon(file)
+
+	if err != nil {
+		return nil, fmt.Errorf("could not load icon %s: %w", full_path, err)
 	}
 
-	return file, nil
+	return icon, nil
+}
+
+// The icons that are built into kb_ui, rather than loaded from a file.
+var builtinIcons = map[string][]byte{
+	"kb_dark":      icons.KB_Dark_Data,
+	"kb_light":     icons.KB_Light_Data,
+	"disconnected": icons.Disconnected_Data,
 }
 
 func ParseIcon(icon string) ([]byte, error) {
 
 	lower_icon := strings.ToLower(icon)
 
-	switch lower_icon {
-	case "kb_dark":
-		return icons.KB_Dark_Data, nil
-	case "kb_light":
-		return icons.KB_Light_Data, nil
-	case "disconnected":
-		return icons.Disconnected_Data, nil
-	default:
-		return loadIconFile(icon)
+	if data, ok := builtinIcons[lower_icon]; ok {
+		return ConvertIcon(data)
 	}
+
+	return loadIconFile(icon)
 }
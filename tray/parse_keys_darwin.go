@@ -0,0 +1,26 @@
+//go:build darwin
+
+package tray
+
+import "golang.design/x/hotkey"
+
+// Keys with no constant in the hotkey library, by their virtual key code.
+// macOS has no F21 - F24.
+var platformKeys = map[string]hotkey.Key{
+	"home":     0x73,
+	"end":      0x77,
+	"pageup":   0x74,
+	"pagedown": 0x79,
+	"insert":   0x72,
+
+	"numpad0": 0x52, "numpad1": 0x53, "numpad2": 0x54, "numpad3": 0x55,
+	"numpad4": 0x56, "numpad5": 0x57, "numpad6": 0x58, "numpad7": 0x59,
+	"numpad8": 0x5b, "numpad9": 0x5c,
+
+	"numpadadd":      0x45,
+	"numpadsubtract": 0x4e,
+	"numpadmultiply": 0x43,
+	"numpaddivide":   0x4b,
+	"numpaddecimal":  0x41,
+	"numpadreturn":   0x4c,
+}
@@ -0,0 +1,54 @@
+//go:build darwin
+
+package tray
+
+import (
+	"testing"
+
+	"golang.design/x/hotkey"
+)
+
+func TestParseKey(t *testing.T) {
+
+	tests := []struct {
+		key  string
+		want hotkey.Key
+	}{
+		{"a", hotkey.KeyA},
+		{"Z", hotkey.KeyZ},
+		{"9", hotkey.Key9},
+		{"esc", hotkey.KeyEscape},
+		{"Escape", hotkey.KeyEscape},
+		{"enter", hotkey.KeyReturn},
+		{"tab", hotkey.KeyTab},
+		{"f13", hotkey.KeyF13},
+		{"F20", hotkey.KeyF20},
+		{"Page_Up", 0x74},
+		{"pgdn", 0x79},
+		{"kp_1", 0x53},
+		{"num1", 0x53},
+		{"numpad 1", 0x53},
+		{"KP_Enter", 0x4c},
+		{"numpadplus", 0x45},
+	}
+
+	for _, test := range tests {
+		got, err := ParseKey(test.key)
+
+		if err != nil {
+			t.Errorf("ParseKey(%q) failed: %s", test.key, err)
+		} else if got != test.want {
+			t.Errorf("ParseKey(%q) = 0x%x, want 0x%x", test.key, got, test.want)
+		}
+	}
+}
+
+func TestParseKeyRejects(t *testing.T) {
+
+	// macOS has no F21 - F24.
+	for _, key := range []string{"", " ", "f21", "f25", "ctrl", "numpad", "kp_x", "enterr"} {
+		if got, err := ParseKey(key); err == nil {
+			t.Errorf("ParseKey(%q) = 0x%x, want an error", key, got)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+//go:build linux
+
+package tray
+
+import "golang.design/x/hotkey"
+
+// Keys with no constant in the hotkey library, by their X11 keysym.
+var platformKeys = map[string]hotkey.Key{
+	// The library maps tab to the escape keysym, so use the real one.
+	"tab": 0xff09,
+
+	// The library digits are all one off (Key1 is the keysym for "0"), so
+	// use the real keysyms, which are just the ASCII digits.
+	"0": 0x30, "1": 0x31, "2": 0x32, "3": 0x33, "4": 0x34,
+	"5": 0x35, "6": 0x36, "7": 0x37, "8": 0x38, "9": 0x39,
+
+	"home":     0xff50,
+	"end":      0xff57,
+	"pageup":   0xff55,
+	"pagedown": 0xff56,
+	"insert":   0xff63,
+
+	"f21": 0xffd2, "f22": 0xffd3, "f23": 0xffd4, "f24": 0xffd5,
+
+	"numpad0": 0xffb0, "numpad1": 0xffb1, "numpad2": 0xffb2, "numpad3": 0xffb3,
+	"numpad4": 0xffb4, "numpad5": 0xffb5, "numpad6": 0xffb6, "numpad7": 0xffb7,
+	"numpad8": 0xffb8, "numpad9": 0xffb9,
+
+	"numpadadd":      0xffab,
+	"numpadsubtract": 0xffad,
+	"numpadmultiply": 0xffaa,
+	"numpaddivide":   0xffaf,
+	"numpaddecimal":  0xffae,
+	"numpadreturn":   0xff8d,
+}
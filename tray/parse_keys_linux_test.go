@@ -0,0 +1,56 @@
+//go:build linux
+
+package tray
+
+import (
+	"testing"
+
+	"golang.design/x/hotkey"
+)
+
+func TestParseKey(t *testing.T) {
+
+	tests := []struct {
+		key  string
+		want hotkey.Key
+	}{
+		{"a", hotkey.KeyA},
+		{"Z", hotkey.KeyZ},
+		{"0", 0x30},
+		{"1", 0x31},
+		{"9", 0x39},
+		{"esc", hotkey.KeyEscape},
+		{"Escape", hotkey.KeyEscape},
+		{"enter", hotkey.KeyReturn},
+		{"f13", hotkey.KeyF13},
+		{"F20", hotkey.KeyF20},
+		{"f24", 0xffd5},
+		{"tab", 0xff09},
+		{"Page_Up", 0xff55},
+		{"pgdn", 0xff56},
+		{"kp_1", 0xffb1},
+		{"num1", 0xffb1},
+		{"numpad 1", 0xffb1},
+		{"KP_Enter", 0xff8d},
+		{"numpadplus", 0xffab},
+	}
+
+	for _, test := range tests {
+		got, err := ParseKey(test.key)
+
+		if err != nil {
+			t.Errorf("ParseKey(%q) failed: %s", test.key, err)
+		} else if got != test.want {
+			t.Errorf("ParseKey(%q) = 0x%x, want 0x%x", test.key, got, test.want)
+		}
+	}
+}
+
+func TestParseKeyRejects(t *testing.T) {
+
+	for _, key := range []string{"", " ", "f25", "ctrl", "numpad", "kp_x", "enterr"} {
+		if got, err := ParseKey(key); err == nil {
+			t.Errorf("ParseKey(%q) = 0x%x, want an error", key, got)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+//go:build windows
+
+package tray
+
+import "golang.design/x/hotkey"
+
+// Keys with no constant in the hotkey library, by their virtual key code.
+// Windows has no separate numpad enter key.
+var platformKeys = map[string]hotkey.Key{
+	"home":     0x24,
+	"end":      0x23,
+	"pageup":   0x21,
+	"pagedown": 0x22,
+	"insert":   0x2d,
+
+	"f21": 0x84, "f22": 0x85, "f23": 0x86, "f24": 0x87,
+
+	"numpad0": 0x60, "numpad1": 0x61, "numpad2": 0x62, "numpad3": 0x63,
+	"numpad4": 0x64, "numpad5": 0x65, "numpad6": 0x66, "numpad7": 0x67,
+	"numpad8": 0x68, "numpad9": 0x69,
+
+	"numpadadd":      0x6b,
+	"numpadsubtract": 0x6d,
+	"numpadmultiply": 0x6a,
+	"numpaddivide":   0x6f,
+	"numpaddecimal":  0x6e,
+}
@@ -0,0 +1,54 @@
+//go:build windows
+
+package tray
+
+import (
+	"testing"
+
+	"golang.design/x/hotkey"
+)
+
+func TestParseKey(t *testing.T) {
+
+	tests := []struct {
+		key  string
+		want hotkey.Key
+	}{
+		{"a", hotkey.KeyA},
+		{"Z", hotkey.KeyZ},
+		{"9", hotkey.Key9},
+		{"esc", hotkey.KeyEscape},
+		{"Escape", hotkey.KeyEscape},
+		{"enter", hotkey.KeyReturn},
+		{"tab", hotkey.KeyTab},
+		{"f13", hotkey.KeyF13},
+		{"F20", hotkey.KeyF20},
+		{"f24", 0x87},
+		{"Page_Up", 0x21},
+		{"pgdn", 0x22},
+		{"kp_1", 0x61},
+		{"num1", 0x61},
+		{"numpad 1", 0x61},
+		{"numpadplus", 0x6b},
+	}
+
+	for _, test := range tests {
+		got, err := ParseKey(test.key)
+
+		if err != nil {
+			t.Errorf("ParseKey(%q) failed: %s", test.key, err)
+		} else if got != test.want {
+			t.Errorf("ParseKey(%q) = 0x%x, want 0x%x", test.key, got, test.want)
+		}
+	}
+}
+
+func TestParseKeyRejects(t *testing.T) {
+
+	// Windows has no separate numpad enter key.
+	for _, key := range []string{"", " ", "f25", "ctrl", "numpad", "kp_x", "enterr", "kp_enter"} {
+		if got, err := ParseKey(key); err == nil {
+			t.Errorf("ParseKey(%q) = 0x%x, want an error", key, got)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package tray
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.design/x/hotkey"
+)
+
+// The canonical modifier names, in the order they are written out.
+var canonicalModifiers = []string{"ctrl", "shift", "alt", "win"}
+
+// Every accepted modifier name, mapped to its canonical name.
+var modifierAliases = map[string]string{
+	"ctrl":    "ctrl",
+	"control": "ctrl",
+	"shift":   "shift",
+	"alt":     "alt",
+	"option":  "alt",
+	"opt":     "alt",
+	"win":     "win",
+	"super":   "win",
+	"meta":    "win",
+	"cmd":     "win",
+	"command": "win",
+}
+
+// Split a modifier string on "-", "+" or spaces, and get the canonical name
+// of each modifier in it. I.e. "Control+Option" is ["ctrl", "alt"].
+// Any modifier name that isn't known is an error, rather than being ignored.
+func TokenizeModifiers(modifiers string) ([]string, error) {
+
+	tokens := strings.FieldsFunc(strings.ToLower(modifiers), func(r rune) bool {
+		return r == '-' || r == '+' || r == ' '
+	})
+
+	if len(tokens) == 0 {
+		return nil, errors.New("no modifiers given")
+	}
+
+	found := map[string]bool{}
+
+	for _, token := range tokens {
+		name, ok := modifierAliases[token]
+
+		if !ok {
+			return nil, fmt.Errorf("unknown modifier: %s", token)
+		}
+
+		found[name] = true
+	}
+
+	names := []string{}
+	for _, name := range canonicalModifiers {
+		if found[name] {
+			names = append(names, name)
+		}
+	}
+
+	return names, nil
+}
+
+// Get the canonical form of a modifier string, i.e. "cmd+control" is "ctrl-win".
+func CanonicalModifiers(modifiers string) (string, error) {
+
+	names, err := TokenizeModifiers(modifiers)
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join(names, "-"), nil
+}
+
+// Parse a modifier string into the modifiers for the current platform.
+func ParseModifiers(modifiers string) ([]hotkey.Modifier, error) {
+
+	names, err := TokenizeModifiers(modifiers)
+
+	if err != nil {
+		return nil, err
+	}
+
+	mods := []hotkey.Modifier{}
+	for _, name := range names {
+		mods = append(mods, platformModifiers[name])
+	}
+
+	return mods, nil
+}
@@ -0,0 +1,68 @@
+package tray
+
+import (
+	"testing"
+
+	"golang.design/x/hotkey"
+	"golang.org/x/exp/slices"
+)
+
+func TestCanonicalModifiers(t *testing.T) {
+
+	tests := []struct {
+		modifiers string
+		want      string
+		fails     bool
+	}{
+		{"ctrl-shift", "ctrl-shift", false},
+		{"shift+ctrl", "ctrl-shift", false},
+		{"Control Option", "ctrl-alt", false},
+		{"cmd+control", "ctrl-win", false},
+		{"super-meta", "win", false},
+		{"opt-alt-shift", "shift-alt", false},
+		{"COMMAND + SHIFT", "shift-win", false},
+		{"ctrl--shift", "ctrl-shift", false},
+		{"", "", true},
+		{"-+ ", "", true},
+		{"shiftctrl", "", true},
+		{"ctrl-alternate", "", true},
+		{"cmdx", "", true},
+	}
+
+	for _, test := range tests {
+		got, err := CanonicalModifiers(test.modifiers)
+
+		if (err != nil) != test.fails || got != test.want {
+			t.Errorf("CanonicalModifiers(%q) = %q, %v, want %q (fails %t)", test.modifiers, got, err, test.want, test.fails)
+		}
+	}
+}
+
+func TestParseModifiers(t *testing.T) {
+
+	got, err := ParseModifiers("Shift+Control")
+
+	if err != nil {
+		t.Fatalf("ParseModifiers failed: %s", err)
+	}
+
+	want := []hotkey.Modifier{platformModifiers["ctrl"], platformModifiers["shift"]}
+	if !slices.Equal(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	if _, err := ParseModifiers("ctrl-hyper"); err == nil {
+		t.Errorf("expected an unknown modifier to fail")
+	}
+}
+
+// Every alias needs a modifier on each platform, or it would silently bind
+// without it.
+func TestPlatformModifiers(t *testing.T) {
+
+	for alias, name := range modifierAliases {
+		if _, ok := platformModifiers[name]; !ok {
+			t.Errorf("%s maps to %s, which has no platform modifier", alias, name)
+		}
+	}
+}
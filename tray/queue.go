@@ -0,0 +1,46 @@
+package tray
+
+import "sync"
+
+// Slow work waiting to run off the event loop, like hooks and notifications.
+// It is run one piece at a time, in the order it was added, so the work for a
+// later change never overtakes an earlier one.
+type workQueue struct {
+	mutex   sync.Mutex
+	pending []func()
+	running bool
+}
+
+// Queue up some work, starting the worker if it isn't already running.
+func (queue *workQueue) add(work func()) {
+
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	queue.pending = append(queue.pending, work)
+
+	if !queue.running {
+		queue.running = true
+		go queue.run()
+	}
+}
+
+// Run the queued work in turn, stopping once the queue is empty.
+func (queue *workQueue) run() {
+
+	for {
+		queue.mutex.Lock()
+
+		if len(queue.pending) == 0 {
+			queue.running = false
+			queue.mutex.Unlock()
+			return
+		}
+
+		work := queue.pending[0]
+		queue.pending = queue.pending[1:]
+		queue.mutex.Unlock()
+
+		work()
+	}
+}
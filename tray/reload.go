@@ -0,0 +1,218 @@
+package tray
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// How often to check the config file for edits.
+const configPollInterval = 2 * time.Second
+
+// Parse every layer binding out of a keyboard's config.
+// Any layer that fails to parse is left out, and reported in the error.
+func MakeKeybindings(state *TrayState, config *KeyboardConfig) ([]Keybinding, error) {
+
+	keybinds := []Keybinding{}
+	errs := []error{}
+
+	for i, binding := range config.LayerInfo {
+
+		keybind, err := MakeKeybinding(state, binding, i)
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("layer %d (%s): %w", i, binding.Name, err))
+			continue
+		}
+
+		keybinds = append(keybinds, keybind)
+	}
+
+	return keybinds, errors.Join(errs...)
+}
+
+// Swap the tray over to use the given config and keyboards.
+// Each keyboard stays in the same layers where they still exist, otherwise it
+// starts again from the first one. Any held layers will never see their
+// release, so are dropped.
+func (state *TrayState) ApplyConfig(config *Config, keyboards []*Keyboard) {
+
+	previous := state.keyboards
+
+	state.config = config
+	state.keyboards = keyboards
+
+	// The config dark mode is only used if the desktop has no preference.
+	state.config_dark_mode = config.DarkMode
+	state.dark_mode = isDarkMode(state.color_scheme, config.DarkMode)
+
+	for _, keyboard := range keyboards {
+		var stack []string
+
+		i := slices.IndexFunc(previous, func(k *Keyboard) bool {
+			return k.name == keyboard.name
+		})
+
+		// A single keyboard is always the same one, even if it was renamed.
+		if i == -1 && len(previous) == 1 && len(keyboards) == 1 {
+			i = 0
+		}
+
+		if i != -1 {
+			old := previous[i]
+			stack = old.stackNames(false)
+
+			for _, flag := range keyboard.flags {
+				if oldFlag := old.FindFlag(flag.name); oldFlag != nil {
+					flag.value = oldFlag.value
+				}
+			}
+
+			if keyboard.FindOutput(old.output) != nil {
+				keyboard.output = old.output
+			}
+		}
+
+		keyboard.restoreStack(stack)
+	}
+}
+
+// Load the user config, logging anything wrong with it. This is the same on
+// startup and reload: problems are only logged, so that a typo in one layer
+// does not stop the rest from working, but a config that can't be read or has
+// no layers at all can't be used.
+func (state *TrayState) checkedConfiguration() (Config, error) {
+
+	config, problems, err := LoadConfiguration()
+
+	if err != nil {
+		return Config{}, err
+	} else if !config.HasLayers() {
+		return Config{}, errors.New("no layers defined")
+	}
+
+	if len(problems) != 0 {
+		state.logger.Println("Problems found in configuration:")
+		logConfigErrors(state, problems)
+	}
+
+	return config, nil
+}
+
+// Parse the layer bindings of every keyboard, logging any that are invalid.
+// A keyboard with no usable layers left can't be used, so gives no keyboards,
+// while any other problem is returned alongside the keyboards.
+func (state *TrayState) checkedKeyboards(config *Config) ([]*Keyboard, error) {
+
+	keyboards, err := MakeKeyboards(state, config)
+
+	if err != nil {
+		state.logger.Printf("Error parsing layers: %s\n", err.Error())
+	}
+
+	for _, keyboard := range keyboards {
+		if len(keyboard.keybinds) != 0 {
+			continue
+		} else if keyboard.name != "" {
+			return nil, fmt.Errorf("no usable layers for keyboard %s", keyboard.name)
+		}
+
+		return nil, errors.New("no usable layers")
+	}
+
+	return keyboards, err
+}
+
+// Re-read the config file, and swap the tray over to it.
+// If the new config can't be used, or any of its layers fail to parse, the old
+// one is kept running, since a half-working reload would drop layers that
+// were working before the edit.
+func (state *TrayState) ReloadConfiguration() error {
+
+	config, err := state.checkedConfiguration()
+
+	if err != nil {
+		state.logger.Printf("Keeping the previous config, failed to load new config: %s\n", err.Error())
+		return err
+	}
+
+	keyboards, err := state.checkedKeyboards(&config)
+
+	if err != nil {
+		state.logger.Printf("Keeping the previous config, failed to parse new layers: %s\n", err.Error())
+		return err
+	}
+
+	// Release all the old bindings before registering the new ones, since
+	// most will be for the same chords.
+	state.StopSources()
+	state.ApplyConfig(&config, keyboards)
+
+	// Record usage under the new keyboards and layers from here on.
+	state.flushStats()
+
+	state.StartSources()
+	state.logger.Println("Reloaded configuration.")
+
+	return nil
+}
+
+// Polls the config file, reloading the tray every time it changes.
+// Polling is used over file events, since many editors save by replacing the
+// file, which is easy to lose track of.
+type ConfigWatcher struct {
+	done chan struct{}
+}
+
+func WatchConfig(state *TrayState) *ConfigWatcher {
+
+	watcher := &ConfigWatcher{make(chan struct{})}
+	configFile := ConfigPath()
+	lastModified := time.Time{}
+
+	if info, err := os.Stat(configFile); err == nil {
+		lastModified = info.ModTime()
+	}
+
+	go func() {
+		ticker := time.NewTicker(configPollInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-watcher.done:
+				return
+			}
+
+			info, err := os.Stat(configFile)
+
+			if err != nil || info.ModTime().Equal(lastModified) {
+				continue
+			}
+
+			lastModified = info.ModTime()
+
+			state.loop.Post(func() {
+				if !state.quitting {
+					state.ReloadConfiguration()
+				}
+			})
+		}
+	}()
+
+	return watcher
+}
+
+func (watcher *ConfigWatcher) Stop() {
+	close(watcher.done)
+}
+
+func logConfigErrors(state *TrayState, problems []ConfigError) {
+	for _, problem := range problems {
+		state.logger.Printf("    %s\n", problem.Error())
+	}
+}
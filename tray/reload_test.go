@@ -0,0 +1,102 @@
+package tray
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestConfig(t *testing.T, config string) {
+
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(ConfigPath()), 0755); err != nil {
+		t.Fatalf("failed to make the config folder: %s", err)
+	}
+
+	if err := os.WriteFile(ConfigPath(), []byte(config), 0644); err != nil {
+		t.Fatalf("failed to write the config: %s", err)
+	}
+}
+
+// Startup and reload accept the same configs, so a problem that startup only
+// logs can't stop every later edit from being picked up. The one difference is
+// a layer that fails to parse, which a reload refuses to keep the old layers.
+func TestReloadMatchesStartup(t *testing.T) {
+
+	state := newTestState(t, `{"layers": `+testLayers+`}`)
+	defer state.loop.Do(state.StopSources)
+
+	layerNames := func() []string {
+		names := []string{}
+
+		state.loop.Do(func() {
+			for _, keybind := range state.keyboards[0].keybinds {
+				names = append(names, keybind.name)
+			}
+		})
+
+		return names
+	}
+
+	tests := []struct {
+		name    string
+		config  string
+		starts  bool
+		reloads bool
+		layers  int
+	}{
+		{"missing icon and unknown field", `{
+			"layers": [
+				{"name": "Base", "mods": "ctrl-shift", "key": "F1", "icon": "missing.png"},
+				{"name": "Gaming", "mods": "ctrl-shift", "key": "F5", "icon": {"text": "G"}, "colour": "red"}
+			]
+		}`, true, true, 2},
+		{"invalid JSON", `{"layers": [`, false, false, 2},
+		{"no layers", `{"layers": []}`, false, false, 2},
+		{"mods typo in every layer", `{
+			"layers": [
+				{"name": "Base", "mods": "ctlr-shift", "key": "F1", "icon": {"text": "B"}},
+				{"name": "Nav", "mods": "ctlr-shift", "key": "F2", "icon": {"text": "N"}}
+			]
+		}`, false, false, 2},
+		{"mods typo in one layer", `{
+			"layers": [
+				{"name": "Base", "mods": "ctrl-shift", "key": "F1", "icon": {"text": "B"}},
+				{"name": "Nav", "mods": "ctlr-shift", "key": "F2", "icon": {"text": "N"}},
+				{"name": "Num", "mods": "ctrl-shift", "key": "F3", "icon": {"text": "1"}}
+			]
+		}`, true, false, 2},
+		{"fixed", `{"layers": ` + testLayers + `}`, true, true, 3},
+	}
+
+	for _, test := range tests {
+		writeTestConfig(t, test.config)
+
+		var started bool
+		var reloadErr error
+
+		state.loop.Do(func() {
+			config, err := state.checkedConfiguration()
+
+			if err == nil {
+				keyboards, _ := state.checkedKeyboards(&config)
+				started = keyboards != nil
+			}
+
+			reloadErr = state.ReloadConfiguration()
+		})
+
+		if started != test.starts {
+			t.Errorf("%s: expected startup to give %t, got %t", test.name, test.starts, started)
+		}
+
+		if (reloadErr == nil) != test.reloads {
+			t.Errorf("%s: expected reload to give %t, got %v", test.name, test.reloads, reloadErr)
+		}
+
+		if names := layerNames(); len(names) != test.layers {
+			t.Errorf("%s: expected %d layers after reloading, got %v", test.name, test.layers, names)
+		}
+	}
+}
@@ -1,41 +1,84 @@
 package tray
 
 import (
+	"errors"
+
 	"github.com/getlantern/systray"
 )
 
-func Start() {
+// Run the tray, until the user quits.
+// Only a single tray can run at once, since they would fight over the hotkeys.
+func Start() error {
+
+	if IsRunning() {
+		return errors.New("kb_ui is already running")
+	}
 
 	trayState := GetInitialState()
 
+	// Everything that touches the tray state runs on its event loop, starting
+	// with the setup itself.
 	onReady := func() {
-		appStart(&trayState)
+		trayState.loop.Do(func() {
+			appStart(&trayState)
+		})
 	}
 	onExit := func() {
 		appEnd(&trayState)
 	}
 
 	systray.Run(onReady, onExit)
+
+	return nil
 }
 
-// On exit, save the current state of the application, un-register any keybindings.
+// On exit, save the current state of the application, stop any layer sources.
 func appEnd(state *TrayState) {
 
-	state.quitting = true
+	var control *ControlServer
+	var config_watcher *ConfigWatcher
+	var theme ThemeProvider
+
+	// Ignore anything still queued up from here on.
+	state.loop.Do(func() {
+		state.quitting = true
+		control = state.control
+		config_watcher = state.config_watcher
+		theme = state.theme
+	})
+
+	// Control clients may be waiting on the event loop, so these are stopped
+	// from outside of it.
+	if control != nil {
+		control.Stop()
+	}
 
-	for _, hk := range *state.keybinds {
-		err := hk.bind.Unregister()
+	if config_watcher != nil {
+		config_watcher.Stop()
+	}
 
-		if err != nil {
-			state.logger.Println("Failed to unregister keybind:", err.Error())
+	if theme != nil {
+		theme.Close()
+	}
+
+	state.loop.Do(func() {
+		state.StopSources()
+
+		if state.stats != nil {
+			state.stats.Stop()
+			state.flushStats()
 		}
 
-		hk.bind = nil
-	}
+		if state.notifier != nil {
+			state.notifier.Close()
+		}
+
+		state.logger.Printf("Final state was %+v\n", state)
 
-	state.logger.Printf("Final state was %+v\n", state)
+		state.SaveCurrentState()
+	})
 
-	state.SaveCurrentState()
+	state.loop.Stop()
 }
 
 // On ready, load the user configuration, setup the keybindings, then just wait
@@ -44,49 +87,71 @@ func appStart(state *TrayState) {
 
 	SetupInitialTrayState(state)
 
-	// Load the user config, but stop if there is nothing defined.
-	config, err := LoadConfiguration()
+	// Load the user config, but stop if there is nothing usable in it.
+	config, err := state.checkedConfiguration()
 
 	if err != nil {
-		state.logger.Printf("Error loading configuration: %s\n", err.Error())
+		state.logger.Printf("Error loading configuration, exiting: %s\n", err.Error())
 		systray.Quit()
 		return
-	} else if len(config.LayerInfo) == 0 {
-		state.logger.Println("No layers defined, exiting.")
+	}
+
+	// Parse the actual layer bindings out, skipping any that are invalid.
+	// There is no previous config to fall back to here, so only stop if a
+	// keyboard has nothing usable left.
+	keyboards, err := state.checkedKeyboards(&config)
+
+	if keyboards == nil {
+		state.logger.Printf("Error parsing layers, exiting: %s\n", err.Error())
 		systray.Quit()
 		return
 	}
 
-	// Load the actual user disconnect icon.
-	*state.disconnect_icon, err = ParseIcon(config.DisconnectIcon)
+	// This starts every keyboard from its first layer.
+	state.ApplyConfig(&config, keyboards)
 
-	if err != nil {
-		state.logger.Printf("Error parsing disconnect icon: %s\n", err.Error())
-		*state.disconnect_icon, _ = ParseIcon("disconnected")
+	// Follow the desktop colour scheme, if it can be read.
+	state.theme, err = NewThemeProvider()
+
+	if err == nil {
+		WatchTheme(state, state.theme)
+	} else {
+		state.logger.Printf("Using the config dark mode setting: %s\n", err.Error())
 	}
 
-	// Parse the actual layer bindings out.
-	for i, binding := range config.LayerInfo {
+	// Set the initial state of the application, from the previous run if there
+	// is one.
+	state.LoadPreviousState()
 
-		keybind := MakeKeybinding(state, binding, i)
-		err = keybind.SetupKeybinding(state)
+	// Track how long is spent in each layer from here on.
+	state.stats = RecordStats(state)
+	state.refreshStatsMenu()
 
-		if err != nil {
-			state.logger.Printf("Error setting up keybind %d: %s\n", i, err.Error())
-			continue
-		}
+	// Save every change from now on, so nothing is lost if kb_ui is killed.
+	state.OnChange(func(SaveState) {
+		state.SaveCurrentState()
+	})
+
+	// Only notify about changes from here on, not the state restored above.
+	state.notifier, err = NewNotifier()
 
-		// Store the binding, so we can unregister it later.
-		*state.keybinds = append(*state.keybinds, keybind)
+	if err != nil {
+		state.logger.Printf("Notifications disabled: %s\n", err.Error())
 	}
 
-	// Set the initial state of the application, if there is one.
-	state.LoadPreviousState()
+	// Finally, start listening for layer changes from every configured source,
+	// and the control socket.
+	state.StartSources()
+
+	control := NewControlServer(state)
+	err = control.Start()
 
-	connectToggleBinding, err := SetupConnectKeybind(state, &config)
 	if err == nil {
-		*state.keybinds = append(*state.keybinds, connectToggleBinding)
+		state.control = control
 	} else {
-		state.logger.Printf("Failed to create connect toggle keybind: %s\n", err.Error())
+		state.logger.Printf("Failed to start control socket: %s\n", err.Error())
 	}
+
+	// Pick up any edits to the config from now on.
+	state.config_watcher = WatchConfig(state)
 }
@@ -0,0 +1,187 @@
+package tray
+
+type LayerEventKind int
+
+const (
+	// The keyboard moved to a new layer, given by LayerName or LayerId.
+	LayerChanged LayerEventKind = iota
+	// A layer was toggled on or off, on top of the current layers.
+	LayerToggled
+	// The output connection flipped, i.e. the board swapped to another host.
+	ConnectToggled
+	// The output connection is now exactly IsConnected.
+	ConnectChanged
+	// A momentary layer is being held, so should only be shown until released.
+	LayerHeld
+	// A momentary layer was released, so whatever was before it is shown again.
+	LayerReleased
+	// The flag given by Flag flipped.
+	FlagToggled
+	// The flag given by Flag is now exactly Value.
+	FlagChanged
+	// The keyboard is now typing into the output given by Output.
+	OutputChanged
+)
+
+// A single change reported by a layer source.
+// For layer events, the layer is matched by name if one is given, falling back
+// to the id (the index of the layer in the config).
+type LayerEvent struct {
+	Kind        LayerEventKind
+	LayerId     int
+	LayerName   string
+	IsConnected bool
+	// The name of the keyboard the event is for, or the first keyboard if empty.
+	Keyboard string
+	Flag     string
+	Value    bool
+	Output   string
+}
+
+// Anything that can tell the tray about layer changes, i.e. global hotkeys,
+// raw HID reports, keyboard LEDs, a socket or a watched file.
+//
+// Start should begin reporting events on the Events channel, and Stop should
+// release everything the source holds, then close the Events channel.
+type LayerSource interface {
+	Start() error
+	Stop() error
+	Events() <-chan LayerEvent
+}
+
+// Build every layer source the config asks for.
+// Hotkeys and the layers menu are always used, everything else is opt-in. The
+// control socket does not depend on the config, so is run separately and
+// survives config reloads.
+func MakeLayerSources(state *TrayState) []LayerSource {
+
+	sources := []LayerSource{}
+
+	for _, keyboard := range state.keyboards {
+		sources = append(sources, NewHotkeySource(state, keyboard), NewMenuSource(state, keyboard))
+
+		if keyboard.config.HidDevice != "" {
+			sources = append(sources, NewHidSource(keyboard.config.HidDevice, keyboard.name))
+		}
+
+		if keyboard.config.LedDevice != "" {
+			sources = append(sources, NewLedSource(keyboard.config.LedDevice, keyboard.name, keyboard.ledFlags()))
+		}
+	}
+
+	return sources
+}
+
+// Start a layer source, and feed everything it reports into the tray, in the
+// order it was reported.
+func (state *TrayState) RunSource(source LayerSource) error {
+
+	err := source.Start()
+
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for event := range source.Events() {
+			state.loop.Post(func() {
+				state.HandleEvent(event)
+			})
+		}
+	}()
+
+	return nil
+}
+
+// Start every layer source for the current keyboards.
+func (state *TrayState) StartSources() {
+
+	for _, source := range MakeLayerSources(state) {
+		err := state.RunSource(source)
+
+		if err != nil {
+			state.logger.Printf("Failed to start layer source: %s\n", err.Error())
+			continue
+		}
+
+		*state.sources = append(*state.sources, source)
+	}
+}
+
+// Stop every running layer source.
+func (state *TrayState) StopSources() {
+
+	for _, source := range *state.sources {
+		err := source.Stop()
+
+		if err != nil {
+			state.logger.Println("Failed to stop layer source:", err.Error())
+		}
+	}
+
+	*state.sources = nil
+}
+
+// Apply a single layer event to the tray. This must be run on the event loop.
+func (state *TrayState) HandleEvent(event LayerEvent) {
+
+	if state.quitting {
+		return
+	}
+
+	keyboard := state.FindKeyboard(event.Keyboard)
+
+	if keyboard == nil {
+		state.logger.Printf("Event for unknown keyboard %q\n", event.Keyboard)
+		return
+	}
+
+	switch event.Kind {
+	case LayerChanged, LayerToggled, LayerHeld, LayerReleased:
+		keybind := keyboard.FindLayer(event.LayerId, event.LayerName)
+
+		if keybind == nil {
+			state.logger.Printf("Layer change to unknown layer %d (%s)\n", event.LayerId, event.LayerName)
+			return
+		}
+
+		switch event.Kind {
+		case LayerChanged:
+			keyboard.SetLayer(keybind)
+		case LayerToggled:
+			keyboard.ToggleLayer(keybind)
+		case LayerHeld:
+			keyboard.HoldLayer(keybind)
+		case LayerReleased:
+			keyboard.ReleaseLayer(keybind)
+		}
+	case ConnectToggled, ConnectChanged, FlagToggled, FlagChanged:
+		name, value := event.Flag, event.Value
+
+		if event.Kind == ConnectToggled || event.Kind == ConnectChanged {
+			name, value = ConnectFlag, event.IsConnected
+		}
+
+		flag := keyboard.FindFlag(name)
+
+		if flag == nil {
+			state.logger.Printf("Change to unknown flag %q\n", name)
+			return
+		}
+
+		if event.Kind == ConnectToggled || event.Kind == FlagToggled {
+			value = !flag.value
+		}
+
+		keyboard.SetFlag(flag, value)
+	case OutputChanged:
+		output := keyboard.FindOutput(event.Output)
+
+		if output == nil {
+			state.logger.Printf("Change to unknown output %q\n", event.Output)
+			return
+		}
+
+		keyboard.SetOutput(output)
+	}
+}
@@ -0,0 +1,208 @@
+package tray
+
+import (
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// How a layer binding changes the layer stack, following ZMK's layer behaviors.
+const (
+	// Move straight to the layer, replacing the whole stack (&to).
+	BehaviorTo = "to"
+	// Push the layer onto the stack, or pop it off again if it is on (&tog).
+	BehaviorToggle = "toggle"
+	// Push the layer onto the stack only while the chord is held (&mo).
+	BehaviorMomentary = "momentary"
+)
+
+var layerBehaviors = []string{BehaviorTo, BehaviorToggle, BehaviorMomentary}
+
+// Get the behavior of a layer binding. The older "momentary" flag is the same
+// as the momentary behavior, and everything else defaults to "to".
+func LayerBehavior(binding LayerConfig) string {
+
+	if binding.Behavior != "" {
+		return strings.ToLower(binding.Behavior)
+	} else if binding.Momentary {
+		return BehaviorMomentary
+	}
+
+	return BehaviorTo
+}
+
+// Move straight to the given layer, replacing every layer of its group on the
+// stack. Layers in other groups are left alone.
+func (keyboard *Keyboard) SetLayer(keybind *Keybinding) {
+
+	stack := slices.DeleteFunc(slices.Clone(keyboard.layer_stack), func(id int) bool {
+		return keyboard.inGroup(id, keybind.group)
+	})
+
+	keyboard.setStack(append(stack, keybind.id))
+}
+
+// Toggle a layer on, on top of the stack, or off if it is already on.
+// The last layer of a group can't be toggled off, since there would be
+// nothing left to show for it.
+func (keyboard *Keyboard) ToggleLayer(keybind *Keybinding) {
+
+	stack := slices.Clone(keyboard.layer_stack)
+	i := slices.Index(stack, keybind.id)
+
+	if i == -1 {
+		stack = append(stack, keybind.id)
+	} else if keyboard.groupSize(keybind.group) > 1 {
+		stack = slices.Delete(stack, i, i+1)
+	}
+
+	keyboard.setStack(stack)
+}
+
+// Push a momentary layer while it is held.
+func (keyboard *Keyboard) HoldLayer(keybind *Keybinding) {
+
+	// Key repeat can send the same chord again while held.
+	if slices.Contains(keyboard.layer_stack, keybind.id) {
+		return
+	}
+
+	keyboard.setStack(append(slices.Clone(keyboard.layer_stack), keybind.id))
+}
+
+// Release a momentary layer, showing whatever is under it again. If the layer
+// was replaced while it was held, i.e. by a "to" layer, there is nothing to do.
+func (keyboard *Keyboard) ReleaseLayer(keybind *Keybinding) {
+
+	i := slices.Index(keyboard.layer_stack, keybind.id)
+
+	if i == -1 || keyboard.groupSize(keybind.group) == 1 {
+		return
+	}
+
+	keyboard.setStack(slices.Delete(slices.Clone(keyboard.layer_stack), i, i+1))
+}
+
+// Check if the layer with the given id is in a group.
+func (keyboard *Keyboard) inGroup(id int, group string) bool {
+
+	keybind := keyboard.FindLayer(id, "")
+
+	return keybind != nil && keybind.group == group
+}
+
+// Count how many layers of a group are on the stack.
+func (keyboard *Keyboard) groupSize(group string) int {
+
+	count := 0
+
+	for _, id := range keyboard.layer_stack {
+		if keyboard.inGroup(id, group) {
+			count++
+		}
+	}
+
+	return count
+}
+
+// Swap to a new layer stack, showing the layer on top of it.
+// Each group whose active layer changed is notified, recorded and has its
+// hooks run, as if it was the only group.
+func (keyboard *Keyboard) setStack(stack []int) {
+
+	if len(stack) == 0 || slices.Equal(stack, keyboard.layer_stack) {
+		return
+	}
+
+	top := keyboard.FindLayer(stack[len(stack)-1], "")
+	if top == nil {
+		return
+	}
+
+	// With nothing on the stack yet, there is no change to tell anything about.
+	initial := len(keyboard.layer_stack) == 0
+	before := keyboard.activeLayers()
+
+	// Make sure the app state is saved.
+	keyboard.layer_stack = stack
+	keyboard.layer_id = top.id
+	keyboard.layer_name = top.name
+
+	state := keyboard.state
+	state.RefreshTray()
+	state.notifyChange()
+
+	if initial {
+		return
+	}
+
+	for i, current := range keyboard.activeLayers() {
+		previous := before[i]
+
+		if current == nil || previous != nil && previous.id == current.id {
+			continue
+		}
+
+		keyboard.notifyLayer(current)
+		keyboard.recordLayerStats(current)
+		keyboard.runLayerHooks(previous, current)
+	}
+}
+
+// Get the names of every layer on the stack, from the bottom up.
+// Momentary layers can be left out, i.e. when saving the stack, since they
+// will never see their chord released.
+func (keyboard *Keyboard) stackNames(withMomentary bool) []string {
+
+	names := []string{}
+
+	for _, id := range keyboard.layer_stack {
+		keybind := keyboard.FindLayer(id, "")
+
+		if keybind != nil && (withMomentary || keybind.behavior != BehaviorMomentary) {
+			names = append(names, keybind.name)
+		}
+	}
+
+	return names
+}
+
+// Rebuild the layer stack from layer names, skipping any that no longer exist.
+// Any group left without a layer starts again from its first layer.
+func (keyboard *Keyboard) restoreStack(names []string) {
+
+	stack := []int{}
+
+	for _, name := range names {
+		keybind := keyboard.FindLayer(-1, name)
+
+		if keybind != nil && keybind.behavior != BehaviorMomentary && !slices.Contains(stack, keybind.id) {
+			stack = append(stack, keybind.id)
+		}
+	}
+
+	// Put the first layer of each missing group at the bottom, keeping the
+	// groups in config order.
+	missing := []int{}
+
+	for _, group := range keyboard.groups {
+		i := slices.IndexFunc(keyboard.keybinds, func(k Keybinding) bool {
+			return k.group == group
+		})
+
+		if !slices.ContainsFunc(stack, func(id int) bool { return keyboard.inGroup(id, group) }) {
+			missing = append(missing, keyboard.keybinds[i].id)
+		}
+	}
+
+	stack = append(missing, stack...)
+
+	// Always redraw, since the layers themselves may have changed.
+	if slices.Equal(stack, keyboard.layer_stack) {
+		keyboard.state.RefreshTray()
+		keyboard.state.notifyChange()
+		return
+	}
+
+	keyboard.setStack(stack)
+}
@@ -0,0 +1,112 @@
+package tray
+
+import (
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestLayerStack(t *testing.T) {
+
+	layers := `{"layers": [
+		{"name": "Base", "mods": "ctrl-shift", "key": "F1", "icon": {"text": "B"}},
+		{"name": "Nav", "mods": "ctrl-shift", "key": "F2", "icon": {"text": "N"}},
+		{"name": "Sym", "mods": "ctrl-shift", "key": "F3", "icon": {"text": "S"}, "behavior": "toggle"},
+		{"name": "Fn", "mods": "ctrl-shift", "key": "F4", "icon": {"text": "F"}, "behavior": "momentary"}
+	]}`
+
+	groups := `{"layers": [
+		{"name": "Base", "mods": "ctrl-shift", "key": "F1", "icon": {"text": "B"}},
+		{"name": "Nav", "mods": "ctrl-shift", "key": "F2", "icon": {"text": "N"}},
+		{"name": "Mac", "mods": "ctrl-shift", "key": "F5", "icon": {"text": "M"}, "group": "os"},
+		{"name": "Win", "mods": "ctrl-shift", "key": "F6", "icon": {"text": "W"}, "group": "os"}
+	]}`
+
+	type step struct {
+		kind  LayerEventKind
+		layer string
+		want  []string
+	}
+
+	tests := []struct {
+		name   string
+		config string
+		start  []string
+		steps  []step
+	}{
+		{"to", layers, []string{"Base"}, []step{
+			{LayerChanged, "Nav", []string{"Nav"}},
+			{LayerChanged, "Nav", []string{"Nav"}},
+			{LayerChanged, "Base", []string{"Base"}},
+		}},
+		{"to replaces everything", layers, []string{"Base"}, []step{
+			{LayerToggled, "Sym", []string{"Base", "Sym"}},
+			{LayerHeld, "Fn", []string{"Base", "Sym", "Fn"}},
+			{LayerChanged, "Nav", []string{"Nav"}},
+		}},
+		{"toggle", layers, []string{"Base"}, []step{
+			{LayerToggled, "Sym", []string{"Base", "Sym"}},
+			{LayerToggled, "Sym", []string{"Base"}},
+			{LayerToggled, "Nav", []string{"Base", "Nav"}},
+			{LayerToggled, "Sym", []string{"Base", "Nav", "Sym"}},
+			{LayerToggled, "Nav", []string{"Base", "Sym"}},
+		}},
+		{"toggle off the last layer", layers, []string{"Base"}, []step{
+			{LayerToggled, "Base", []string{"Base"}},
+			{LayerChanged, "Sym", []string{"Sym"}},
+			{LayerToggled, "Sym", []string{"Sym"}},
+		}},
+		{"momentary", layers, []string{"Base"}, []step{
+			{LayerHeld, "Fn", []string{"Base", "Fn"}},
+			{LayerHeld, "Fn", []string{"Base", "Fn"}},
+			{LayerReleased, "Fn", []string{"Base"}},
+			{LayerReleased, "Fn", []string{"Base"}},
+		}},
+		{"release below the top", layers, []string{"Base"}, []step{
+			{LayerHeld, "Fn", []string{"Base", "Fn"}},
+			{LayerToggled, "Sym", []string{"Base", "Fn", "Sym"}},
+			{LayerReleased, "Fn", []string{"Base", "Sym"}},
+		}},
+		{"release after being replaced", layers, []string{"Base"}, []step{
+			{LayerHeld, "Fn", []string{"Base", "Fn"}},
+			{LayerChanged, "Nav", []string{"Nav"}},
+			{LayerReleased, "Fn", []string{"Nav"}},
+		}},
+		{"release the last layer", layers, []string{"Base"}, []step{
+			{LayerChanged, "Fn", []string{"Fn"}},
+			{LayerReleased, "Fn", []string{"Fn"}},
+		}},
+		{"groups", groups, []string{"Base", "Mac"}, []step{
+			{LayerChanged, "Nav", []string{"Mac", "Nav"}},
+			{LayerChanged, "Win", []string{"Nav", "Win"}},
+			{LayerToggled, "Win", []string{"Nav", "Win"}},
+			{LayerToggled, "Mac", []string{"Nav", "Win", "Mac"}},
+			{LayerToggled, "Win", []string{"Nav", "Mac"}},
+			{LayerChanged, "Base", []string{"Mac", "Base"}},
+		}},
+	}
+
+	for _, test := range tests {
+		state := newTestState(t, test.config)
+
+		var stack []string
+		state.loop.Do(func() { stack = state.keyboards[0].stackNames(true) })
+
+		if !slices.Equal(stack, test.start) {
+			t.Errorf("%s: expected to start on %v, got %v", test.name, test.start, stack)
+			continue
+		}
+
+		for i, step := range test.steps {
+			state.loop.Do(func() {
+				state.HandleEvent(LayerEvent{Kind: step.kind, LayerName: step.layer})
+				stack = state.keyboards[0].stackNames(true)
+			})
+
+			if !slices.Equal(stack, step.want) {
+				t.Errorf("%s: step %d (%s) expected %v, got %v", test.name, i, step.layer, step.want, stack)
+				break
+			}
+		}
+	}
+}
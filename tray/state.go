@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/adrg/xdg"
 	"github.com/getlantern/systray"
@@ -12,65 +15,174 @@ import (
 )
 
 type TrayState struct {
-	tray            *TrayItems
-	logger          *log.Logger
-	keybinds        *[]Keybinding
-	layer_id        int
-	layer_name      string
-	is_connected    bool
-	dark_mode       bool
-	disconnect_icon *[]byte
-	quitting        bool
+	tray             *TrayItems
+	logger           *log.Logger
+	config           *Config
+	keyboards        []*Keyboard
+	dark_mode        bool
+	config_dark_mode bool
+	color_scheme     ColorScheme
+	sources          *[]LayerSource
+	control          *ControlServer
+	config_watcher   *ConfigWatcher
+	theme            ThemeProvider
+	notifier         Notifier
+	notification_id  uint32 // Only used from the notifications queue.
+	stats            *StatsRecorder
+	watchers         *[]func(SaveState)
+	hooks            *workQueue
+	notifications    *workQueue
+	loop             *EventLoop
+	quitting         bool
 }
 
+// The state of a keyboard. With more than one keyboard, the state of each is
+// in Keyboards, and the top level is the state of the first, for anything
+// that only knows about a single keyboard.
 type SaveState struct {
-	LayerId     int    `json:"id"`
-	LayerName   string `json:"name"`
-	IsConnected bool   `json:"is_connected"`
+	LayerId     int             `json:"id"`
+	LayerName   string          `json:"name"`
+	IsConnected bool            `json:"is_connected"`
+	Stack       []string        `json:"stack,omitempty"`
+	Groups      []GroupState    `json:"groups,omitempty"`
+	Flags       map[string]bool `json:"flags,omitempty"`
+	Output      string          `json:"output,omitempty"`
+	Keyboard    string          `json:"keyboard,omitempty"`
+	Keyboards   []SaveState     `json:"keyboards,omitempty"`
+	Version     int             `json:"version,omitempty"`
+}
+
+// The active layer of a single layer group.
+type GroupState struct {
+	Group string `json:"group"`
+	Layer string `json:"layer"`
+}
+
+// The version of the state file format, bumped whenever it changes in a way
+// older versions can't read. Files from before versioning are version 0.
+const stateVersion = 1
+
+// Get the path of the file the state is saved to between runs.
+func StatePath() (string, error) {
+	return xdg.DataFile("kb_ui/state.json")
 }
 
 // Get the initial application state.
 // Mostly just sets up the logger.
 func GetInitialState() TrayState {
 
-	var keybinds []Keybinding
+	var sources []LayerSource
+	var watchers []func(SaveState)
 
 	log_file_path, _ := xdg.DataFile("kb_ui/kb_ui.log")
 	f, _ := os.OpenFile(log_file_path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 
 	logger := log.New(f, "", log.LstdFlags)
 
-	disconnected_icon, _ := ParseIcon("disconnected")
-	quitting := false
-
-	return TrayState{nil, logger, &keybinds, 0, "", true, false, &disconnected_icon, quitting}
+	return TrayState{
+		logger:        logger,
+		color_scheme:  NoPreference,
+		sources:       &sources,
+		watchers:      &watchers,
+		hooks:         &workQueue{},
+		notifications: &workQueue{},
+		loop:          NewEventLoop(),
+	}
 
 }
 
-// Save the current state of the application, un-register any keybindings.
+// Save the current state of the application, so it can be restored next run.
+// This is done after every change, so nothing is lost if kb_ui is killed.
+// Nothing is saved until the keyboards are loaded, so a run that fails to
+// start up leaves the previous state alone.
 func (state *TrayState) SaveCurrentState() {
 
-	dataFile, err := xdg.DataFile("kb_ui/state.json")
+	if len(state.keyboards) == 0 {
+		return
+	}
+
+	dataFile, err := StatePath()
 	if err != nil {
 		state.logger.Printf("Failed to create state file: %s\n", err.Error())
 		return
 	}
 
-	endState := SaveState{state.layer_id, state.layer_name, state.is_connected}
+	endState := SaveState{}
+
+	for i, keyboard := range state.keyboards {
+		saved := keyboard.savedState()
+
+		if i == 0 {
+			endState = saved
+		}
+
+		if len(state.keyboards) > 1 {
+			endState.Keyboards = append(endState.Keyboards, saved)
+		}
+	}
+
+	endState.Version = stateVersion
+
 	json, err := json.MarshalIndent(endState, "", "    ")
 	if err != nil {
 		state.logger.Printf("Failed to marshall state: %s\n", err.Error())
 		return
 	}
 
-	err = os.WriteFile(dataFile, json, 0644)
+	err = writeFileAtomic(dataFile, json)
 
 	if err != nil {
 		state.logger.Printf("Failed to save state: %s\n", err.Error())
 		return
 	}
+}
+
+// Get the state of a keyboard to save. Momentary layers can't still be held
+// next time, so save what is under them.
+func (keyboard *Keyboard) savedState() SaveState {
+
+	saved := keyboard.CurrentState()
+	saved.Stack = keyboard.stackNames(false)
+
+	if len(saved.Stack) != 0 {
+		top := keyboard.FindLayer(-1, saved.Stack[len(saved.Stack)-1])
+		saved.LayerId = top.id
+		saved.LayerName = top.name
+	}
 
-	state.logger.Printf("Saved state %+v\n", endState)
+	return saved
+}
+
+// Write a file by writing a temporary file next to it, then renaming it into
+// place, so the file is never left half written.
+func writeFileAtomic(path string, data []byte) error {
+
+	temp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(temp.Name())
+
+	_, err = temp.Write(data)
+
+	if err == nil {
+		err = temp.Sync()
+	}
+
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err == nil {
+		err = os.Chmod(temp.Name(), 0644)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(temp.Name(), path)
 }
 
 // Load the previous run file, if it exists.
@@ -78,7 +190,7 @@ func (state *TrayState) SaveCurrentState() {
 // Don't worry about errors, just ignore it since its only a save state of
 // the previous state.
 func (state *TrayState) LoadPreviousState() {
-	dataFile, err := xdg.DataFile("kb_ui/state.json")
+	dataFile, err := StatePath()
 
 	if err != nil {
 		state.logger.Printf("Could not find state file: %s\n", err.Error())
@@ -97,22 +209,168 @@ func (state *TrayState) LoadPreviousState() {
 
 	if err != nil {
 		state.logger.Printf("Could not unmarshal state file: %s\n", err.Error())
+		state.quarantineFile(dataFile)
 		return
 	}
 
 	state.logger.Printf("Loaded previous state: %+v\n", prevState)
 
-	// If this is the state we left off in last time, set it.
-	state.tray.layer.SetTitle(fmt.Sprintf("%s Layer", prevState.LayerName))
-	i := slices.IndexFunc(*state.keybinds, func(k Keybinding) bool {
-		return k.id == prevState.LayerId && k.name == prevState.LayerName
-	})
-	keybind := (*state.keybinds)[i]
+	// Layers are always restored by name, which every version has saved.
+	if prevState.Version > stateVersion {
+		state.logger.Printf("State file is from a newer version (%d), restoring what can be read.\n", prevState.Version)
+	}
+
+	// Older state files only have a single keyboard.
+	saved := prevState.Keyboards
+	if len(saved) == 0 {
+		saved = []SaveState{prevState}
+	}
+
+	for _, keyboard := range state.keyboards {
+		j := slices.IndexFunc(saved, func(s SaveState) bool {
+			return s.Keyboard == keyboard.name
+		})
+
+		// A single keyboard is always the same one, even if it was renamed.
+		if j == -1 && len(state.keyboards) == 1 && len(saved) == 1 {
+			j = 0
+		}
+
+		if j != -1 {
+			keyboard.restoreState(saved[j])
+		}
+	}
+}
+
+// Put a keyboard back in the state it was saved in.
+func (keyboard *Keyboard) restoreState(saved SaveState) {
 
-	state.layer_id = i
-	state.layer_name = keybind.name
-	state.is_connected = prevState.IsConnected
+	// Older state files only have the single layer, rather than a stack.
+	stack := saved.Stack
+	if len(stack) == 0 {
+		for _, group := range saved.Groups {
+			stack = append(stack, group.Layer)
+		}
+	}
+
+	if len(stack) == 0 {
+		stack = []string{saved.LayerName}
+	}
+
+	for _, flag := range keyboard.flags {
+		if flag.name == ConnectFlag {
+			flag.value = saved.IsConnected
+		} else {
+			flag.value = saved.Flags[flag.name]
+		}
+	}
+
+	if keyboard.FindOutput(saved.Output) != nil {
+		keyboard.output = saved.Output
+	}
+	keyboard.restoreStack(stack)
+}
+
+// Move a state or stats file that can't be read out of the way, so it is kept
+// to look at later, rather than being overwritten by the next save.
+func (state *TrayState) quarantineFile(path string) {
+
+	quarantine := fmt.Sprintf("%s.corrupt-%s", path, time.Now().Format("20060102-150405"))
+	err := os.Rename(path, quarantine)
+
+	if err != nil {
+		state.logger.Printf("Failed to move corrupt file: %s\n", err.Error())
+		return
+	}
+
+	state.logger.Printf("Moved corrupt file to %s\n", quarantine)
+}
+
+// Update the tray icon and title to match the current state.
+func (state *TrayState) RefreshTray() {
+
+	titles := []string{}
+	icons := [][]byte{}
+
+	for _, keyboard := range state.keyboards {
+		keybind := keyboard.FindLayer(keyboard.layer_id, "")
+		if keybind == nil {
+			continue
+		}
+
+		titles = append(titles, keyboard.label(keyboard.title()))
+		icons = append(icons, keyboard.icon())
+
+		for id, item := range keyboard.layer_items {
+			if slices.Contains(keyboard.layer_stack, id) {
+				item.Check()
+			} else {
+				item.Uncheck()
+			}
+		}
+
+		for _, flag := range keyboard.flags {
+			if flag.item != nil && flag.value {
+				flag.item.Check()
+			} else if flag.item != nil {
+				flag.item.Uncheck()
+			}
+		}
+	}
+
+	state.refreshOutputs()
 
-	// Finally, update the tray with this loaded state.
-	systray.SetIcon(*keybind.GetIcon(state))
+	if len(icons) == 0 {
+		return
+	} else if len(icons) == 1 && len(state.keyboards[0].groups) < 2 {
+		state.tray.layer.SetTitle(fmt.Sprintf("%s Layer", titles[0]))
+		systray.SetIcon(icons[0])
+		return
+	} else if len(icons) == 1 {
+		state.tray.layer.SetTitle(titles[0])
+		systray.SetIcon(icons[0])
+		return
+	}
+
+	state.tray.layer.SetTitle(strings.Join(titles, " · "))
+	icon, err := combineIcons(icons)
+
+	if err != nil {
+		state.logger.Printf("Failed to combine keyboard icons: %s\n", err.Error())
+		icon = icons[0]
+	}
+
+	systray.SetIcon(icon)
+}
+
+// Get a snapshot of the current layer and connection state of every keyboard.
+func (state *TrayState) CurrentState() SaveState {
+
+	current := SaveState{}
+
+	for i, keyboard := range state.keyboards {
+		if i == 0 {
+			current = keyboard.CurrentState()
+		}
+
+		if len(state.keyboards) > 1 {
+			current.Keyboards = append(current.Keyboards, keyboard.CurrentState())
+		}
+	}
+
+	return current
+}
+
+// Register a function to be called with the new state after every change.
+func (state *TrayState) OnChange(watcher func(SaveState)) {
+	*state.watchers = append(*state.watchers, watcher)
+}
+
+func (state *TrayState) notifyChange() {
+
+	current := state.CurrentState()
+
+	for _, watcher := range *state.watchers {
+		watcher(current)
+	}
 }
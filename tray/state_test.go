@@ -0,0 +1,133 @@
+package tray
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+// Write the state file the next LoadPreviousState reads.
+func writeStateFile(t *testing.T, contents string) string {
+
+	t.Helper()
+
+	path, err := StatePath()
+	if err != nil {
+		t.Fatalf("failed to find the state file: %s", err)
+	}
+
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		t.Fatalf("failed to write the state file: %s", err)
+	}
+
+	return path
+}
+
+func TestLoadPreviousState(t *testing.T) {
+
+	reordered := `{"layers": [
+		{"name": "Num", "mods": "ctrl-shift", "key": "F3", "icon": {"text": "1"}},
+		{"name": "Base", "mods": "ctrl-shift", "key": "F1", "icon": {"text": "B"}},
+		{"name": "Sym", "mods": "ctrl-shift", "key": "F4", "icon": {"text": "S"}, "behavior": "toggle"}
+	]}`
+
+	keyboards := `{"keyboards": [
+		{"name": "Sofle", "layers": ` + testLayers + `},
+		{"name": "Lily", "layers": ` + testLayers + `}
+	]}`
+
+	tests := []struct {
+		name      string
+		config    string
+		file      string
+		want      [][]string
+		connected bool
+	}{
+		{"version 0", `{"layers": ` + testLayers + `}`,
+			`{"id": 1, "name": "Nav", "is_connected": false}`,
+			[][]string{{"Nav"}}, false},
+		{"version 0 with a stale id", reordered,
+			`{"id": 0, "name": "Base", "is_connected": true}`,
+			[][]string{{"Base"}}, true},
+		{"reordered layers", reordered,
+			`{"version": 1, "id": 2, "name": "Sym", "is_connected": true, "stack": ["Base", "Sym"]}`,
+			[][]string{{"Base", "Sym"}}, true},
+		{"removed layer", reordered,
+			`{"version": 1, "id": 2, "name": "Nav", "is_connected": true, "stack": ["Base", "Nav"]}`,
+			[][]string{{"Base"}}, true},
+		{"every layer removed", reordered,
+			`{"version": 1, "id": 1, "name": "Nav", "is_connected": true, "stack": ["Nav"]}`,
+			[][]string{{"Num"}}, true},
+		{"renamed single keyboard", `{"keyboards": [{"name": "Sofle", "layers": ` + testLayers + `}]}`,
+			`{"version": 1, "keyboards": [{"keyboard": "Corne", "name": "Num", "is_connected": true, "stack": ["Num"]}]}`,
+			[][]string{{"Num"}}, true},
+		{"keyboards by name", keyboards,
+			`{"version": 1, "keyboards": [
+				{"keyboard": "Lily", "name": "Nav", "is_connected": true, "stack": ["Nav"]},
+				{"keyboard": "Corne", "name": "Base", "is_connected": true, "stack": ["Base"]},
+				{"keyboard": "Sofle", "name": "Num", "is_connected": false, "stack": ["Num"]}
+			]}`,
+			[][]string{{"Num"}, {"Nav"}}, false},
+		{"missing keyboard", keyboards,
+			`{"version": 1, "keyboards": [{"keyboard": "Lily", "name": "Num", "is_connected": true, "stack": ["Num"]}]}`,
+			[][]string{{"Base"}, {"Num"}}, true},
+	}
+
+	for _, test := range tests {
+		state := newTestState(t, test.config)
+		writeStateFile(t, test.file)
+
+		var stacks [][]string
+		var connected bool
+
+		state.loop.Do(func() {
+			state.LoadPreviousState()
+
+			for _, keyboard := range state.keyboards {
+				stacks = append(stacks, keyboard.stackNames(true))
+			}
+
+			connected = state.keyboards[0].connected()
+		})
+
+		if !slices.EqualFunc(stacks, test.want, slices.Equal[[]string]) {
+			t.Errorf("%s: expected stacks %v, got %v", test.name, test.want, stacks)
+		}
+
+		if connected != test.connected {
+			t.Errorf("%s: expected connected %t, got %t", test.name, test.connected, connected)
+		}
+	}
+}
+
+func TestLoadPreviousStateQuarantinesCorruptFile(t *testing.T) {
+
+	state := newTestState(t, `{"layers": `+testLayers+`}`)
+	path := writeStateFile(t, `{"id": 1, "name": "Na`)
+
+	var stack []string
+	state.loop.Do(func() {
+		state.LoadPreviousState()
+		stack = state.keyboards[0].stackNames(true)
+	})
+
+	if !slices.Equal(stack, []string{"Base"}) {
+		t.Errorf("expected to stay on Base, got %v", stack)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected the corrupt file to be moved, got %v", err)
+	}
+
+	moved, _ := filepath.Glob(path + ".corrupt-*")
+
+	if len(moved) != 1 {
+		t.Fatalf("expected one quarantined file, got %v", moved)
+	}
+
+	if contents, _ := os.ReadFile(moved[0]); string(contents) != `{"id": 1, "name": "Na` {
+		t.Errorf("expected the quarantined file to be kept as is, got %q", contents)
+	}
+}
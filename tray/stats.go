@@ -0,0 +1,481 @@
+package tray
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/adrg/xdg"
+	"golang.org/x/exp/slices"
+)
+
+// The version of the stats file format, like the state file.
+const statsVersion = 1
+
+// How often the time spent in the current layer is added to the stats, saved,
+// and shown in the menu.
+const statsFlushInterval = time.Minute
+
+// The day keys in the stats file.
+const statsDayFormat = "2006-01-02"
+
+// The stats file was read, but isn't valid, so should be moved out of the way.
+var errInvalidStats = errors.New("invalid stats file")
+
+// Usage of a single layer over a day, split by the hour of the day (in local
+// time) so it can be filtered and used to see when each layer is used.
+type LayerUsage struct {
+	Seconds  [24]float64 `json:"seconds"`
+	Switches [24]int     `json:"switches"`
+}
+
+type DayUsage struct {
+	Layers      map[string]*LayerUsage `json:"layers"`
+	Connects    [24]int                `json:"connects"`
+	Disconnects [24]int                `json:"disconnects"`
+}
+
+// Every recorded day of usage, keyed by date.
+type UsageStats struct {
+	Version int                  `json:"version"`
+	Days    map[string]*DayUsage `json:"days"`
+}
+
+// A summary of usage since a given time, for reports.
+type StatsReport struct {
+	Since       time.Time     `json:"since"`
+	Layers      []LayerReport `json:"layers"`
+	Connects    int           `json:"connects"`
+	Disconnects int           `json:"disconnects"`
+}
+
+// The usage of a single layer in a report. Hours is the total time spent in
+// the layer during each hour of the day, to show when it is used.
+type LayerReport struct {
+	Name     string      `json:"name"`
+	Seconds  float64     `json:"seconds"`
+	Switches int         `json:"switches"`
+	Hours    [24]float64 `json:"hours"`
+}
+
+// Get the path of the stats file, which lives next to the state file.
+func StatsPath() (string, error) {
+	return xdg.DataFile("kb_ui/stats.json")
+}
+
+// Load the stats file, or start new stats if there isn't one yet.
+func LoadStats() (*UsageStats, error) {
+
+	stats := &UsageStats{statsVersion, map[string]*DayUsage{}}
+	path, err := StatsPath()
+
+	if err != nil {
+		return stats, err
+	}
+
+	file, err := os.ReadFile(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return stats, nil
+	} else if err != nil {
+		return stats, err
+	}
+
+	err = json.Unmarshal(file, stats)
+
+	if err != nil {
+		return &UsageStats{statsVersion, map[string]*DayUsage{}}, fmt.Errorf("%w: %w", errInvalidStats, err)
+	}
+
+	if stats.Days == nil {
+		stats.Days = map[string]*DayUsage{}
+	}
+
+	return stats, nil
+}
+
+// Save the stats, in the same crash-safe way as the state file.
+func (stats *UsageStats) Save() error {
+
+	path, err := StatsPath()
+
+	if err != nil {
+		return err
+	}
+
+	stats.Version = statsVersion
+	data, err := json.MarshalIndent(stats, "", "    ")
+
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(path, data)
+}
+
+func (stats *UsageStats) day(at time.Time) *DayUsage {
+
+	key := at.Format(statsDayFormat)
+	day, ok := stats.Days[key]
+
+	if !ok {
+		day = &DayUsage{Layers: map[string]*LayerUsage{}}
+		stats.Days[key] = day
+	}
+
+	return day
+}
+
+func (stats *UsageStats) layer(at time.Time, name string) *LayerUsage {
+
+	day := stats.day(at)
+	layer, ok := day.Layers[name]
+
+	if !ok {
+		layer = &LayerUsage{}
+		day.Layers[name] = layer
+	}
+
+	return layer
+}
+
+// Add the time spent in a layer, split over every hour it covers.
+func (stats *UsageStats) AddDwell(name string, from time.Time, to time.Time) {
+
+	for from.Before(to) {
+		hourEnd := time.Date(from.Year(), from.Month(), from.Day(), from.Hour(), 0, 0, 0, from.Location()).Add(time.Hour)
+		end := to
+
+		if hourEnd.Before(to) {
+			end = hourEnd
+		}
+
+		stats.layer(from, name).Seconds[from.Hour()] += end.Sub(from).Seconds()
+		from = end
+	}
+}
+
+// Count a switch into a layer.
+func (stats *UsageStats) AddSwitch(name string, at time.Time) {
+	stats.layer(at, name).Switches[at.Hour()]++
+}
+
+// Count a change to the output connection.
+func (stats *UsageStats) AddConnect(connected bool, at time.Time) {
+
+	if connected {
+		stats.day(at).Connects[at.Hour()]++
+	} else {
+		stats.day(at).Disconnects[at.Hour()]++
+	}
+}
+
+// Sum up all the usage since the given time, with the most used layer first.
+// Usage is only split by the hour, so all of the hour containing since is
+// included.
+func (stats *UsageStats) Report(since time.Time) StatsReport {
+
+	report := StatsReport{Since: since, Layers: []LayerReport{}}
+	layers := map[string]*LayerReport{}
+	start := time.Date(since.Year(), since.Month(), since.Day(), since.Hour(), 0, 0, 0, since.Location())
+
+	for key, day := range stats.Days {
+		date, err := time.ParseInLocation(statsDayFormat, key, since.Location())
+
+		if err != nil {
+			continue
+		}
+
+		for hour := 0; hour < 24; hour++ {
+			if date.Add(time.Duration(hour) * time.Hour).Before(start) {
+				continue
+			}
+
+			report.Connects += day.Connects[hour]
+			report.Disconnects += day.Disconnects[hour]
+
+			for name, usage := range day.Layers {
+				layer, ok := layers[name]
+
+				if !ok {
+					layer = &LayerReport{Name: name}
+					layers[name] = layer
+				}
+
+				layer.Seconds += usage.Seconds[hour]
+				layer.Switches += usage.Switches[hour]
+				layer.Hours[hour] += usage.Seconds[hour]
+			}
+		}
+	}
+
+	for _, layer := range layers {
+		if layer.Seconds > 0 || layer.Switches > 0 {
+			report.Layers = append(report.Layers, *layer)
+		}
+	}
+
+	sortLayerReports(report.Layers)
+
+	return report
+}
+
+// Sort layers with the most used first.
+func sortLayerReports(layers []LayerReport) {
+
+	sort.Slice(layers, func(i, j int) bool {
+		if layers[i].Seconds != layers[j].Seconds {
+			return layers[i].Seconds > layers[j].Seconds
+		}
+
+		return layers[i].Name < layers[j].Name
+	})
+}
+
+// Parse how far back a report should go, either as a duration like 7d, 12h
+// or 2w, or a date like 2024-05-01.
+func ParseSince(value string, now time.Time) (time.Time, error) {
+
+	if date, err := time.ParseInLocation(statsDayFormat, value, now.Location()); err == nil {
+		return date, nil
+	}
+
+	units := map[string]time.Duration{
+		"w": 7 * 24 * time.Hour,
+		"d": 24 * time.Hour,
+	}
+
+	for suffix, unit := range units {
+		var count int
+
+		if _, err := fmt.Sscanf(value, "%d"+suffix, &count); err == nil && strings.HasSuffix(value, suffix) && count >= 0 {
+			return now.Add(-time.Duration(count) * unit), nil
+		}
+	}
+
+	duration, err := time.ParseDuration(value)
+
+	if err != nil || duration < 0 {
+		return time.Time{}, fmt.Errorf("invalid time %q, should be like 7d, 12h or 2024-05-01", value)
+	}
+
+	return now.Add(-duration), nil
+}
+
+// Format a duration as hours and minutes, i.e. 5h 12m.
+func FormatDwell(seconds float64) string {
+
+	minutes := int(seconds / 60)
+
+	if minutes < 60 {
+		return fmt.Sprintf("%dm", minutes)
+	} else if minutes%60 == 0 {
+		return fmt.Sprintf("%dh", minutes/60)
+	}
+
+	return fmt.Sprintf("%dh %dm", minutes/60, minutes%60)
+}
+
+// Records layer usage from the tray, as the layer and connection changes.
+// The time spent in the current layer is added as it is left, or every
+// statsFlushInterval, whichever is first.
+type StatsRecorder struct {
+	stats   *UsageStats
+	cursors map[statsGroup]*statsCursor
+	done    chan struct{}
+}
+
+// Each layer group of each keyboard is recorded separately, since they are
+// all active at once.
+type statsGroup struct {
+	keyboard string
+	group    string
+}
+
+// The layer a group is in, and when the time in it was last added.
+type statsCursor struct {
+	layer string
+	since time.Time
+}
+
+// Start recording usage, from the current layer, and keep the menu summary
+// up to date.
+func RecordStats(state *TrayState) *StatsRecorder {
+
+	stats, err := LoadStats()
+
+	if err != nil {
+		state.logger.Printf("Starting new stats: %s\n", err.Error())
+	}
+
+	// Only move the file aside if it was read but is corrupt, rather than
+	// just unreadable right now.
+	if errors.Is(err, errInvalidStats) {
+		if path, err := StatsPath(); err == nil {
+			state.quarantineFile(path)
+		}
+	}
+
+	recorder := &StatsRecorder{stats, nil, make(chan struct{})}
+	recorder.resetCursors(state, time.Now())
+
+	go func() {
+		ticker := time.NewTicker(statsFlushInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-recorder.done:
+				return
+			}
+
+			state.loop.Post(func() {
+				if !state.quitting {
+					state.flushStats()
+				}
+			})
+		}
+	}()
+
+	return recorder
+}
+
+// Stop the flush timer. The stats should be flushed once more after this.
+func (recorder *StatsRecorder) Stop() {
+	close(recorder.done)
+}
+
+// Add the time spent in the current layers so far, then save the stats.
+func (state *TrayState) flushStats() {
+
+	recorder := state.stats
+	if recorder == nil {
+		return
+	}
+
+	now := time.Now()
+
+	for _, cursor := range recorder.cursors {
+		recorder.stats.AddDwell(cursor.layer, cursor.since, now)
+	}
+
+	// Carry on from the current layers, since a config reload can add, remove
+	// or rename them.
+	recorder.resetCursors(state, now)
+
+	if err := recorder.stats.Save(); err != nil {
+		state.logger.Printf("Failed to save stats: %s\n", err.Error())
+	}
+
+	state.refreshStatsMenu()
+}
+
+// Start counting from the active layer of every group of every keyboard.
+func (recorder *StatsRecorder) resetCursors(state *TrayState, now time.Time) {
+
+	recorder.cursors = map[statsGroup]*statsCursor{}
+
+	for _, keyboard := range state.keyboards {
+		for _, keybind := range keyboard.activeLayers() {
+			if keybind != nil {
+				recorder.cursors[statsGroup{keyboard.name, keybind.group}] = &statsCursor{keyboard.statsLayer(keybind), now}
+			}
+		}
+	}
+}
+
+// Get the name a layer is recorded under. With more than one keyboard, layers
+// are recorded as "Keyboard/Layer", since they may share names.
+func (keyboard *Keyboard) statsLayer(keybind *Keybinding) string {
+
+	if len(keyboard.state.keyboards) < 2 || keyboard.name == "" {
+		return keybind.name
+	}
+
+	return fmt.Sprintf("%s/%s", keyboard.name, keybind.name)
+}
+
+// Record a switch into a layer of a keyboard.
+func (keyboard *Keyboard) recordLayerStats(keybind *Keybinding) {
+
+	recorder := keyboard.state.stats
+	if recorder == nil {
+		return
+	}
+
+	now := time.Now()
+	layer := keyboard.statsLayer(keybind)
+	group := statsGroup{keyboard.name, keybind.group}
+	cursor, ok := recorder.cursors[group]
+
+	if ok && cursor.layer == layer {
+		return
+	} else if ok {
+		recorder.stats.AddDwell(cursor.layer, cursor.since, now)
+	}
+
+	recorder.stats.AddSwitch(layer, now)
+	recorder.cursors[group] = &statsCursor{layer, now}
+
+	keyboard.state.refreshStatsMenu()
+}
+
+// Record a change to the output connection.
+func (keyboard *Keyboard) recordConnectStats() {
+
+	if keyboard.state.stats != nil {
+		keyboard.state.stats.stats.AddConnect(keyboard.connected(), time.Now())
+	}
+}
+
+// Show how long has been spent in each layer today, i.e. "Today: Typing 5h, Gaming 1h".
+func (state *TrayState) refreshStatsMenu() {
+
+	if state.stats == nil || state.tray == nil || state.tray.today == nil {
+		return
+	}
+
+	now := time.Now()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	report := state.stats.stats.Report(midnight)
+
+	// Include the time in the current layers that hasn't been flushed yet.
+	for _, cursor := range state.stats.cursors {
+		current := slices.IndexFunc(report.Layers, func(layer LayerReport) bool {
+			return layer.Name == cursor.layer
+		})
+
+		if current == -1 {
+			report.Layers = append(report.Layers, LayerReport{Name: cursor.layer})
+			current = len(report.Layers) - 1
+		}
+
+		report.Layers[current].Seconds += now.Sub(cursor.since).Seconds()
+	}
+
+	sortLayerReports(report.Layers)
+
+	summary := []string{}
+
+	for _, layer := range report.Layers {
+		if len(summary) == 3 {
+			break
+		}
+
+		if layer.Seconds >= 60 {
+			summary = append(summary, fmt.Sprintf("%s %s", layer.Name, FormatDwell(layer.Seconds)))
+		}
+	}
+
+	if len(summary) == 0 {
+		state.tray.today.SetTitle("Today: nothing yet")
+		return
+	}
+
+	state.tray.today.SetTitle("Today: " + strings.Join(summary, ", "))
+}
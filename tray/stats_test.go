@@ -0,0 +1,177 @@
+package tray
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// A zone without daylight saving, so every day has 24 hours.
+var statsZone = time.FixedZone("test", 2*60*60)
+
+func statsTime(day int, hour int, minute int) time.Time {
+	return time.Date(2024, time.May, day, hour, minute, 0, 0, statsZone)
+}
+
+func TestParseSince(t *testing.T) {
+
+	now := statsTime(10, 12, 30)
+
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"7d", statsTime(3, 12, 30)},
+		{"0d", now},
+		{"2w", time.Date(2024, time.April, 26, 12, 30, 0, 0, statsZone)},
+		{"12h", statsTime(10, 0, 30)},
+		{"90m", statsTime(10, 11, 0)},
+		{"2024-05-01", statsTime(1, 0, 0)},
+	}
+
+	for _, test := range tests {
+		got, err := ParseSince(test.value, now)
+
+		if err != nil {
+			t.Errorf("ParseSince(%q) failed: %s", test.value, err)
+		} else if !got.Equal(test.want) {
+			t.Errorf("ParseSince(%q) = %s, want %s", test.value, got, test.want)
+		}
+	}
+
+	for _, value := range []string{"", "d", "7", "7x", "7dx", "-1d", "-5h", "2024-13-01", "yesterday"} {
+		if got, err := ParseSince(value, now); err == nil {
+			t.Errorf("ParseSince(%q) = %s, want an error", value, got)
+		}
+	}
+}
+
+func TestStatsBuckets(t *testing.T) {
+
+	stats := &UsageStats{statsVersion, map[string]*DayUsage{}}
+
+	stats.AddDwell("Base", statsTime(1, 10, 30), statsTime(1, 12, 15))
+	stats.AddDwell("Nav", statsTime(1, 23, 30), statsTime(2, 0, 45))
+	stats.AddSwitch("Base", statsTime(1, 10, 30))
+	stats.AddSwitch("Base", statsTime(1, 10, 59))
+	stats.AddConnect(true, statsTime(1, 9, 0))
+	stats.AddConnect(false, statsTime(2, 0, 10))
+
+	first, second := stats.Days["2024-05-01"], stats.Days["2024-05-02"]
+
+	if first == nil || second == nil || len(stats.Days) != 2 {
+		t.Fatalf("expected two days, got %v", stats.Days)
+	}
+
+	base := first.Layers["Base"]
+	if base.Seconds[10] != 1800 || base.Seconds[11] != 3600 || base.Seconds[12] != 900 {
+		t.Errorf("expected Base to be split over 10, 11 and 12, got %v", base.Seconds)
+	}
+
+	if base.Switches[10] != 2 {
+		t.Errorf("expected two switches at 10, got %v", base.Switches)
+	}
+
+	if first.Layers["Nav"].Seconds[23] != 1800 || second.Layers["Nav"].Seconds[0] != 2700 {
+		t.Errorf("expected Nav to be split over midnight, got %v and %v", first.Layers["Nav"].Seconds, second.Layers["Nav"].Seconds)
+	}
+
+	if first.Connects[9] != 1 || second.Disconnects[0] != 1 {
+		t.Errorf("expected a connect at 9 and a disconnect at 0, got %v and %v", first.Connects, second.Disconnects)
+	}
+}
+
+func TestStatsReport(t *testing.T) {
+
+	stats := &UsageStats{statsVersion, map[string]*DayUsage{}}
+
+	stats.AddDwell("Old", statsTime(1, 8, 0), statsTime(1, 9, 0))
+	stats.AddDwell("Base", statsTime(2, 9, 0), statsTime(2, 11, 0))
+	stats.AddDwell("Nav", statsTime(2, 11, 0), statsTime(2, 13, 0))
+	stats.AddDwell("Num", statsTime(3, 0, 0), statsTime(3, 1, 0))
+	stats.AddDwell("Sym", statsTime(3, 1, 0), statsTime(3, 2, 0))
+	stats.AddSwitch("Base", statsTime(2, 9, 0))
+	stats.AddSwitch("Idle", statsTime(2, 12, 0))
+	stats.AddConnect(true, statsTime(1, 8, 0))
+	stats.AddConnect(false, statsTime(2, 10, 0))
+	stats.AddConnect(true, statsTime(3, 0, 0))
+
+	// All of the hour holding since is counted, so Base gets an hour.
+	report := stats.Report(statsTime(2, 10, 30))
+
+	names := []string{}
+	for _, layer := range report.Layers {
+		names = append(names, layer.Name)
+	}
+
+	if want := []string{"Nav", "Base", "Num", "Sym", "Idle"}; !slices.Equal(names, want) {
+		t.Fatalf("expected layers %v, got %v", want, names)
+	}
+
+	base := report.Layers[1]
+	if base.Seconds != 3600 || base.Switches != 0 || base.Hours[10] != 3600 || base.Hours[9] != 0 {
+		t.Errorf("expected only Base from 10, got %+v", base)
+	}
+
+	nav := report.Layers[0]
+	if nav.Seconds != 7200 || nav.Hours[11] != 3600 || nav.Hours[12] != 3600 {
+		t.Errorf("expected two hours of Nav, got %+v", nav)
+	}
+
+	if report.Layers[4].Switches != 1 || report.Layers[4].Seconds != 0 {
+		t.Errorf("expected Idle to only have its switch, got %+v", report.Layers[4])
+	}
+
+	if report.Connects != 1 || report.Disconnects != 1 {
+		t.Errorf("expected one connect and one disconnect, got %d and %d", report.Connects, report.Disconnects)
+	}
+
+	if empty := stats.Report(statsTime(4, 0, 0)); len(empty.Layers) != 0 || empty.Connects != 0 {
+		t.Errorf("expected nothing after the last day, got %+v", empty)
+	}
+}
+
+func TestRecordStatsQuarantine(t *testing.T) {
+
+	state := newTestState(t, `{"layers": `+testLayers+`}`)
+
+	path, err := StatsPath()
+	if err != nil {
+		t.Fatalf("failed to find the stats file: %s", err)
+	}
+
+	record := func() {
+		state.loop.Do(func() { RecordStats(state).Stop() })
+	}
+
+	// A file that can't be read right now is left alone.
+	if err := os.Mkdir(path, 0755); err != nil {
+		t.Fatalf("failed to make the stats folder: %s", err)
+	}
+
+	record()
+
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		t.Errorf("expected an unreadable stats file to be left alone, got %v", err)
+	}
+
+	os.Remove(path)
+
+	// A corrupt one is moved out of the way.
+	if err := os.WriteFile(path, []byte(`{"version": 1, "days": {`), 0644); err != nil {
+		t.Fatalf("failed to write the stats file: %s", err)
+	}
+
+	record()
+
+	if moved, _ := filepath.Glob(path + ".corrupt-*"); len(moved) != 1 {
+		t.Errorf("expected one quarantined file, got %v", moved)
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected the corrupt file to be moved, got %v", err)
+	}
+}
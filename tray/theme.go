@@ -0,0 +1,68 @@
+package tray
+
+// The colour scheme the desktop asks apps to use.
+type ColorScheme uint32
+
+// These match the values of the freedesktop color-scheme setting.
+const (
+	NoPreference ColorScheme = iota
+	PreferDark
+	PreferLight
+)
+
+// Something that can tell the tray the system colour scheme, i.e. the desktop
+// portal on Linux. Changes should report every change to the scheme after
+// it was first read, and be closed by Close.
+type ThemeProvider interface {
+	ColorScheme() (ColorScheme, error)
+	Changes() <-chan ColorScheme
+	Close() error
+}
+
+// Should the dark icons be used for the given scheme. If the desktop has no
+// preference, the darkMode setting in the config is used instead.
+func isDarkMode(scheme ColorScheme, fallback bool) bool {
+
+	switch scheme {
+	case PreferDark:
+		return true
+	case PreferLight:
+		return false
+	}
+
+	return fallback
+}
+
+// Read the current colour scheme, and keep the tray icon in step with it.
+func WatchTheme(state *TrayState, provider ThemeProvider) {
+
+	scheme, err := provider.ColorScheme()
+
+	if err != nil {
+		state.logger.Printf("Failed to read colour scheme: %s\n", err.Error())
+	}
+
+	state.SetColorScheme(scheme)
+
+	go func() {
+		for scheme := range provider.Changes() {
+			state.loop.Post(func() {
+				state.SetColorScheme(scheme)
+			})
+		}
+	}()
+}
+
+// Update the colour scheme, re-drawing the tray if that flips the theme.
+func (state *TrayState) SetColorScheme(scheme ColorScheme) {
+
+	state.color_scheme = scheme
+	dark_mode := isDarkMode(scheme, state.config_dark_mode)
+
+	if dark_mode == state.dark_mode {
+		return
+	}
+
+	state.dark_mode = dark_mode
+	state.RefreshTray()
+}
@@ -0,0 +1,132 @@
+//go:build linux
+
+package tray
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	portalName       = "org.freedesktop.portal.Desktop"
+	portalPath       = "/org/freedesktop/portal/desktop"
+	portalSettings   = "org.freedesktop.portal.Settings"
+	appearanceNs     = "org.freedesktop.appearance"
+	colorSchemeKey   = "color-scheme"
+	portalSignalSize = 8
+
+	// The scheme is read on startup, so don't wait long on a stuck portal.
+	portalTimeout = time.Second
+)
+
+// The colour scheme, as read from the freedesktop settings portal.
+type portalTheme struct {
+	conn    *dbus.Conn
+	signals chan *dbus.Signal
+	changes chan ColorScheme
+}
+
+func NewThemeProvider() (ThemeProvider, error) {
+
+	conn, err := dbus.SessionBusPrivate()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = conn.Auth(nil); err == nil {
+		err = conn.Hello()
+	}
+
+	if err == nil {
+		err = conn.AddMatchSignal(
+			dbus.WithMatchObjectPath(portalPath),
+			dbus.WithMatchInterface(portalSettings),
+			dbus.WithMatchMember("SettingChanged"),
+		)
+	}
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	theme := &portalTheme{
+		conn,
+		make(chan *dbus.Signal, portalSignalSize),
+		make(chan ColorScheme),
+	}
+
+	conn.Signal(theme.signals)
+
+	go func() {
+		defer close(theme.changes)
+
+		// The signal channel is closed along with the connection.
+		for signal := range theme.signals {
+			if len(signal.Body) != 3 || signal.Body[0] != appearanceNs || signal.Body[1] != colorSchemeKey {
+				continue
+			}
+
+			value, ok := signal.Body[2].(dbus.Variant)
+			if !ok {
+				continue
+			}
+
+			scheme, err := parseColorScheme(value)
+			if err == nil {
+				theme.changes <- scheme
+			}
+		}
+	}()
+
+	return theme, nil
+}
+
+func (theme *portalTheme) ColorScheme() (ColorScheme, error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), portalTimeout)
+	defer cancel()
+
+	portal := theme.conn.Object(portalName, portalPath)
+	value := dbus.Variant{}
+
+	err := portal.CallWithContext(ctx, portalSettings+".ReadOne", 0, appearanceNs, colorSchemeKey).Store(&value)
+
+	// Older portals only have Read, which wraps the value in a second variant.
+	if err != nil && ctx.Err() == nil {
+		err = portal.CallWithContext(ctx, portalSettings+".Read", 0, appearanceNs, colorSchemeKey).Store(&value)
+
+		if inner, ok := value.Value().(dbus.Variant); ok {
+			value = inner
+		}
+	}
+
+	if err != nil {
+		return NoPreference, err
+	}
+
+	return parseColorScheme(value)
+}
+
+func (theme *portalTheme) Changes() <-chan ColorScheme {
+	return theme.changes
+}
+
+func (theme *portalTheme) Close() error {
+	return theme.conn.Close()
+}
+
+func parseColorScheme(value dbus.Variant) (ColorScheme, error) {
+
+	scheme, ok := value.Value().(uint32)
+
+	if !ok {
+		return NoPreference, fmt.Errorf("unexpected colour scheme value: %s", value.String())
+	}
+
+	return ColorScheme(scheme), nil
+}
@@ -0,0 +1,94 @@
+//go:build linux
+
+package tray
+
+import (
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// A settings portal, which can answer with a colour scheme, only have the
+// older Read method, or hang until the test ends.
+type fakePortal struct {
+	scheme uint32
+	legacy bool
+	hung   chan struct{}
+}
+
+func (portal *fakePortal) ReadOne(namespace string, key string) (dbus.Variant, *dbus.Error) {
+
+	if portal.hung != nil {
+		<-portal.hung
+	}
+
+	if portal.legacy {
+		return dbus.Variant{}, dbus.MakeFailedError(dbus.ErrMsgUnknownMethod)
+	}
+
+	return dbus.MakeVariant(portal.scheme), nil
+}
+
+func (portal *fakePortal) Read(namespace string, key string) (dbus.Variant, *dbus.Error) {
+	return dbus.MakeVariant(dbus.MakeVariant(portal.scheme)), nil
+}
+
+// Start a bus with the given portal on it, and a theme provider using it.
+func startTestPortal(t *testing.T, portal *fakePortal) ThemeProvider {
+
+	t.Helper()
+
+	address := startTestBus(t)
+	t.Setenv("DBUS_SESSION_BUS_ADDRESS", address)
+
+	portalConn, err := dbus.Connect(address)
+	if err != nil {
+		t.Fatalf("failed to connect the portal: %s", err)
+	}
+	t.Cleanup(func() { portalConn.Close() })
+
+	portalConn.Export(portal, portalPath, portalSettings)
+
+	if reply, err := portalConn.RequestName(portalName, dbus.NameFlagDoNotQueue); err != nil || reply != dbus.RequestNameReplyPrimaryOwner {
+		t.Fatalf("failed to own %s: %v", portalName, err)
+	}
+
+	provider, err := NewThemeProvider()
+	if err != nil {
+		t.Fatalf("failed to connect to the portal: %s", err)
+	}
+	t.Cleanup(func() { provider.Close() })
+
+	return provider
+}
+
+func TestPortalColorScheme(t *testing.T) {
+
+	for _, portal := range []*fakePortal{{scheme: uint32(PreferDark)}, {scheme: uint32(PreferLight), legacy: true}} {
+		scheme, err := startTestPortal(t, portal).ColorScheme()
+
+		if err != nil || scheme != ColorScheme(portal.scheme) {
+			t.Errorf("expected scheme %d (legacy %t), got %d, %v", portal.scheme, portal.legacy, scheme, err)
+		}
+	}
+}
+
+// A portal that never answers can't hold up startup.
+func TestPortalTimeout(t *testing.T) {
+
+	portal := &fakePortal{hung: make(chan struct{})}
+	provider := startTestPortal(t, portal)
+	t.Cleanup(func() { close(portal.hung) })
+
+	start := time.Now()
+	scheme, err := provider.ColorScheme()
+
+	if err == nil || scheme != NoPreference {
+		t.Errorf("expected a hung portal to fail with no preference, got %d, %v", scheme, err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 2*portalTimeout {
+		t.Errorf("expected to give up after %s, took %s", portalTimeout, elapsed)
+	}
+}
@@ -0,0 +1,105 @@
+package tray
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// A colour scheme that the test picks, instead of the desktop.
+type fakeTheme struct {
+	scheme  ColorScheme
+	err     error
+	changes chan ColorScheme
+}
+
+func newFakeTheme(scheme ColorScheme, err error) *fakeTheme {
+	return &fakeTheme{scheme, err, make(chan ColorScheme)}
+}
+
+func (theme *fakeTheme) ColorScheme() (ColorScheme, error) {
+	return theme.scheme, theme.err
+}
+
+func (theme *fakeTheme) Changes() <-chan ColorScheme {
+	return theme.changes
+}
+
+func (theme *fakeTheme) Close() error {
+	close(theme.changes)
+	return nil
+}
+
+const themeTestConfig = `{
+	"layers": [{"name": "Base", "mods": "ctrl-shift", "key": "F1", "icon": "kb_light", "dark_icon": "kb_dark"}],
+	"darkMode": %t
+}`
+
+// Wait for the tray to pick up a change from the provider.
+func waitForDarkMode(t *testing.T, state *TrayState, want bool) {
+
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+
+	for {
+		var dark_mode bool
+		var icon, want_icon *[]byte
+
+		state.loop.Do(func() {
+			dark_mode = state.dark_mode
+			keyboard := state.keyboards[0]
+			icon = keyboard.keybinds[0].GetIcon(keyboard)
+
+			want_icon = keyboard.keybinds[0].icon
+			if want {
+				want_icon = keyboard.keybinds[0].dark_icon
+			}
+		})
+
+		if dark_mode == want && icon == want_icon {
+			return
+		} else if time.Now().After(deadline) {
+			t.Fatalf("expected dark mode %t, got %t", want, dark_mode)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestThemeFollowsProvider(t *testing.T) {
+
+	for _, configDark := range []bool{false, true} {
+		state := newTestState(t, fmt.Sprintf(themeTestConfig, configDark))
+		theme := newFakeTheme(PreferDark, nil)
+
+		state.loop.Do(func() { WatchTheme(state, theme) })
+		waitForDarkMode(t, state, true)
+
+		theme.changes <- PreferLight
+		waitForDarkMode(t, state, false)
+
+		// With no preference, the config decides.
+		theme.changes <- NoPreference
+		waitForDarkMode(t, state, configDark)
+
+		theme.changes <- PreferDark
+		waitForDarkMode(t, state, true)
+
+		theme.Close()
+	}
+}
+
+// If the scheme can't be read, the config decides.
+func TestThemeProviderError(t *testing.T) {
+
+	for _, configDark := range []bool{false, true} {
+		state := newTestState(t, fmt.Sprintf(themeTestConfig, configDark))
+		theme := newFakeTheme(NoPreference, errors.New("no portal"))
+
+		state.loop.Do(func() { WatchTheme(state, theme) })
+		waitForDarkMode(t, state, configDark)
+
+		theme.Close()
+	}
+}
@@ -0,0 +1,11 @@
+//go:build windows
+
+package tray
+
+import "errors"
+
+// Only the freedesktop portal is supported for now, so the darkMode config
+// setting is always used here.
+func NewThemeProvider() (ThemeProvider, error) {
+	return nil, errors.New("colour scheme detection is only supported on linux")
+}
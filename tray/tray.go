@@ -10,8 +10,13 @@ import (
 
 type TrayItems struct {
 	layer  *systray.MenuItem
+	output *systray.MenuItem
+	today  *systray.MenuItem
+	layers *systray.MenuItem
+	flags  *systray.MenuItem
 	config *systray.MenuItem
 	quit   *systray.MenuItem
+	spare  map[menuSlot][]*systray.MenuItem
 }
 
 var Version string
@@ -28,6 +33,18 @@ func SetupInitialTrayState(state *TrayState) {
 	// previous run file.
 	mCurrentLayer := systray.AddMenuItem("Default Layer", "The current keyboard layer")
 
+	// The output the keyboard is typing into, only shown if outputs are set up.
+	mOutput := systray.AddMenuItem("Output: unknown", "The output the keyboard is typing into")
+	mOutput.Hide()
+
+	// A summary of how long has been spent in each layer today.
+	mToday := systray.AddMenuItem("Today: nothing yet", "Time spent in each layer today")
+	mToday.Disable()
+
+	// The layers to pick from, which are filled in once the config is loaded.
+	mLayers := systray.AddMenuItem("Layers", "Manually swap to a layer")
+	mFlags := systray.AddMenuItem("Flags", "Manually turn flags on or off")
+
 	// Add the final entries to configure or quit the application.
 	systray.AddSeparator()
 	mConfigure := systray.AddMenuItem("Configure", "Open the app config file")
@@ -48,7 +65,16 @@ func SetupInitialTrayState(state *TrayState) {
 		}
 	}()
 
-	state.tray = &TrayItems{mCurrentLayer, mConfigure, mQuit}
+	state.tray = &TrayItems{
+		layer:  mCurrentLayer,
+		output: mOutput,
+		today:  mToday,
+		layers: mLayers,
+		flags:  mFlags,
+		config: mConfigure,
+		quit:   mQuit,
+		spare:  map[menuSlot][]*systray.MenuItem{},
+	}
 }
 
 // Get version string, this will be set dynamically for releases to git hash.
@@ -0,0 +1,400 @@
+package tray
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"golang.design/x/hotkey"
+	"golang.org/x/exp/slices"
+)
+
+// A single problem with the config, along with where in the JSON it is.
+type ConfigError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (err ConfigError) Error() string {
+	return fmt.Sprintf("%s: %s", err.Path, err.Message)
+}
+
+// Check the raw JSON of a config file, as well as the config it describes.
+// Unlike ValidateConfig, this can also catch misspelt or unknown fields.
+func ValidateConfigFile(file []byte) []ConfigError {
+
+	cfg, err := ParseConfig(file)
+
+	if err != nil {
+		return []ConfigError{{"$", fmt.Sprintf("invalid JSON: %s", err.Error())}}
+	}
+
+	raw := map[string]any{}
+	json.Unmarshal(file, &raw)
+
+	errs := findUnknownFields(raw, reflect.TypeOf(cfg), "$")
+
+	return append(errs, ValidateConfig(cfg)...)
+}
+
+// Check a config for anything that would stop a layer from working.
+func ValidateConfig(cfg Config) []ConfigError {
+
+	errs := []ConfigError{}
+	// Hotkeys are global, so chords can't be shared between keyboards either.
+	chords := map[string]string{}
+
+	if len(cfg.Keyboards) == 0 {
+		return validateKeyboard("$", cfg.KeyboardConfig, chords)
+	}
+
+	if len(cfg.LayerInfo) != 0 {
+		errs = append(errs, ConfigError{"$.layers", "layers should be given for each keyboard, not at the top level, when keyboards are used"})
+	}
+
+	names := map[string]string{}
+
+	for i, keyboard := range cfg.Keyboards {
+		path := fmt.Sprintf("$.keyboards[%d]", i)
+
+		if keyboard.Name == "" && len(cfg.Keyboards) > 1 {
+			errs = append(errs, ConfigError{path + ".name", "keyboard has no name"})
+		} else if other, ok := names[keyboard.Name]; ok {
+			errs = append(errs, ConfigError{path + ".name", fmt.Sprintf("duplicate keyboard name %q, also used by %s", keyboard.Name, other)})
+		} else {
+			names[keyboard.Name] = path
+		}
+
+		errs = append(errs, validateKeyboard(path, keyboard, chords)...)
+	}
+
+	return errs
+}
+
+// Check the layers and connect settings of a single keyboard.
+func validateKeyboard(root string, cfg KeyboardConfig, chords map[string]string) []ConfigError {
+
+	errs := []ConfigError{}
+	names := map[string]string{}
+	groups := map[string]string{}
+
+	if len(cfg.LayerInfo) == 0 {
+		errs = append(errs, ConfigError{root + ".layers", "no layers defined"})
+	}
+
+	for i, layer := range cfg.LayerInfo {
+		path := fmt.Sprintf("%s.layers[%d]", root, i)
+
+		if layer.Name == "" {
+			errs = append(errs, ConfigError{path + ".name", "layer has no name"})
+		} else if other, ok := names[layer.Name]; ok {
+			errs = append(errs, ConfigError{path + ".name", fmt.Sprintf("duplicate layer name %q, also used by %s", layer.Name, other)})
+		} else {
+			names[layer.Name] = path
+			groups[layer.Name] = layer.Group
+		}
+
+		if behavior := strings.ToLower(layer.Behavior); behavior != "" && !slices.Contains(layerBehaviors, behavior) {
+			errs = append(errs, ConfigError{path + ".behavior", fmt.Sprintf("unknown behavior %q, should be one of %s", layer.Behavior, strings.Join(layerBehaviors, ", "))})
+		} else if layer.Momentary && behavior != "" && behavior != BehaviorMomentary {
+			errs = append(errs, ConfigError{path + ".momentary", fmt.Sprintf("momentary layer has the %q behavior", layer.Behavior)})
+		}
+
+		errs = append(errs, validateChord(path+".mods", path+".key", layer.Mods, layer.Key, chords)...)
+		errs = append(errs, validateIcon(path+".icon", layer.Icon, false)...)
+		errs = append(errs, validateIcon(path+".dark_icon", layer.DarkIcon, false)...)
+		if _, err := ParseUrgency(layer.Urgency); err != nil {
+			errs = append(errs, ConfigError{path + ".urgency", err.Error()})
+		}
+
+		errs = append(errs, validateHooks(path+".on_enter", layer.OnEnter)...)
+		errs = append(errs, validateHooks(path+".on_exit", layer.OnExit)...)
+	}
+
+	// The connect chords are optional, but need both halves if they are used.
+	// connectMods can be used by the set keys alone.
+	setKeys := cfg.ConnectSetKey != "" || cfg.DisconnectSetKey != ""
+
+	if cfg.ConnectKey != "" || cfg.ConnectMods != "" && !setKeys {
+		errs = append(errs, validateChord(root+".connectMods", root+".connectKey", cfg.ConnectMods, cfg.ConnectKey, chords)...)
+	}
+
+	if chord := cfg.connectChord(cfg.ConnectSetMods, cfg.ConnectSetKey); chord != nil {
+		errs = append(errs, validateChord(root+".connectSetMods", root+".connectSetKey", chord.Mods, chord.Key, chords)...)
+	} else if cfg.ConnectSetMods != "" {
+		errs = append(errs, ConfigError{root + ".connectSetKey", "connectSetMods is given without a connectSetKey"})
+	}
+
+	if chord := cfg.connectChord(cfg.DisconnectSetMods, cfg.DisconnectSetKey); chord != nil {
+		errs = append(errs, validateChord(root+".disconnectSetMods", root+".disconnectSetKey", chord.Mods, chord.Key, chords)...)
+	} else if cfg.DisconnectSetMods != "" {
+		errs = append(errs, ConfigError{root + ".disconnectSetKey", "disconnectSetMods is given without a disconnectSetKey"})
+	}
+
+	for i, combo := range cfg.Combos {
+		errs = append(errs, validateCombo(fmt.Sprintf("%s.combos[%d]", root, i), combo, groups)...)
+	}
+
+	flags := map[string]string{}
+
+	for i, flag := range cfg.Flags {
+		path := fmt.Sprintf("%s.flags[%d]", root, i)
+
+		if flag.Name == "" {
+			errs = append(errs, ConfigError{path + ".name", "flag has no name"})
+		} else if other, ok := flags[flag.Name]; ok {
+			errs = append(errs, ConfigError{path + ".name", fmt.Sprintf("duplicate flag name %q, also used by %s", flag.Name, other)})
+		} else {
+			flags[flag.Name] = path
+		}
+
+		if flag.Name == ConnectFlag && cfg.hasConnectFields() {
+			errs = append(errs, ConfigError{path, "the connect and disconnect fields can't be used alongside the connected flag, move them into the flag instead"})
+		}
+
+		flagChords := []*ChordConfig{flag.Set, flag.Clear, flag.Toggle}
+
+		for j, name := range []string{"set", "clear", "toggle"} {
+			if chord := flagChords[j]; chord != nil {
+				errs = append(errs, validateChord(path+"."+name+".mods", path+"."+name+".key", chord.Mods, chord.Key, chords)...)
+			}
+		}
+
+		errs = append(errs, validateIcon(path+".icon", flag.Icon, false)...)
+		errs = append(errs, validateIcon(path+".off_icon", flag.OffIcon, false)...)
+	}
+
+	outputs := map[string]string{}
+
+	for i, output := range cfg.Outputs {
+		path := fmt.Sprintf("%s.outputs[%d]", root, i)
+
+		if output.Name == "" {
+			errs = append(errs, ConfigError{path + ".name", "output has no name"})
+		} else if other, ok := outputs[output.Name]; ok {
+			errs = append(errs, ConfigError{path + ".name", fmt.Sprintf("duplicate output name %q, also used by %s", output.Name, other)})
+		} else {
+			outputs[output.Name] = path
+		}
+
+		if output.Mods != "" || output.Key != "" {
+			errs = append(errs, validateChord(path+".mods", path+".key", output.Mods, output.Key, chords)...)
+		}
+
+		errs = append(errs, validateIcon(path+".icon", output.Icon, false)...)
+	}
+
+	errs = append(errs, validateIcon(root+".disconnectIcon", cfg.DisconnectIcon, false)...)
+	errs = append(errs, validateHooks(root+".on_connect", cfg.OnConnect)...)
+	errs = append(errs, validateHooks(root+".on_disconnect", cfg.OnDisconnect)...)
+
+	return errs
+}
+
+// Check a combo only uses layers that exist, and could all be active at once.
+func validateCombo(path string, combo ComboConfig, groups map[string]string) []ConfigError {
+
+	errs := validateIcon(path+".icon", combo.Icon, true)
+	errs = append(errs, validateIcon(path+".dark_icon", combo.DarkIcon, false)...)
+	used := map[string]string{}
+
+	if len(combo.Layers) == 0 {
+		errs = append(errs, ConfigError{path + ".layers", "combo has no layers"})
+	}
+
+	for i, name := range combo.Layers {
+		layerPath := fmt.Sprintf("%s.layers[%d]", path, i)
+		group, ok := groups[name]
+
+		if !ok {
+			errs = append(errs, ConfigError{layerPath, fmt.Sprintf("unknown layer %q", name)})
+		} else if other, ok := used[group]; ok {
+			errs = append(errs, ConfigError{layerPath, fmt.Sprintf("layer %q is in the same group as %q, so they are never active together", name, other)})
+		} else {
+			used[group] = name
+		}
+	}
+
+	return errs
+}
+
+// Check every hook command has something to run.
+func validateHooks(path string, commands []string) []ConfigError {
+
+	errs := []ConfigError{}
+
+	for i, command := range commands {
+		if strings.TrimSpace(command) == "" {
+			errs = append(errs, ConfigError{fmt.Sprintf("%s[%d]", path, i), "empty hook command"})
+		}
+	}
+
+	return errs
+}
+
+// Check a single mods + key chord parses, and has not already been used.
+func validateChord(modsPath string, keyPath string, mods string, key string, chords map[string]string) []ConfigError {
+
+	errs := []ConfigError{}
+	parsedMods, err := ParseModifiers(mods)
+
+	if err != nil {
+		errs = append(errs, ConfigError{modsPath, err.Error()})
+	}
+
+	parsedKey, err := ParseKey(key)
+
+	if err != nil {
+		errs = append(errs, ConfigError{keyPath, err.Error()})
+	}
+
+	if len(errs) != 0 {
+		return errs
+	}
+
+	chord := chordId(parsedMods, parsedKey)
+
+	if other, ok := chords[chord]; ok {
+		canonical, _ := CanonicalModifiers(mods)
+		return []ConfigError{{keyPath, fmt.Sprintf("chord %s+%s is already used by %s", canonical, key, other)}}
+	}
+
+	chords[chord] = keyPath
+
+	return nil
+}
+
+// Get a unique id for a chord, regardless of the order the modifiers were given.
+func chordId(mods []hotkey.Modifier, key hotkey.Key) string {
+
+	ids := []string{}
+	for _, mod := range mods {
+		ids = append(ids, fmt.Sprint(mod))
+	}
+
+	sort.Strings(ids)
+
+	return fmt.Sprintf("%s+%d", strings.Join(ids, "+"), key)
+}
+
+// Check an icon is either built in, exists in the config folder, or can be
+// generated from its spec.
+func validateIcon(path string, icon IconSpec, required bool) []ConfigError {
+
+	if icon.IsGenerated() {
+		if _, err := RenderIcon(icon, false, 16); err != nil {
+			return []ConfigError{{path, err.Error()}}
+		}
+
+		if utf8.RuneCountInString(icon.Text) > maxIconText {
+			return []ConfigError{{path + ".text", fmt.Sprintf("icon text %q is too long to read, use at most %d characters", icon.Text, maxIconText)}}
+		}
+
+		return nil
+	}
+
+	if icon.IsEmpty() {
+		if required {
+			return []ConfigError{{path, "no icon given"}}
+		}
+
+		return nil
+	}
+
+	if _, ok := builtinIcons[strings.ToLower(icon.Name)]; ok {
+		return nil
+	}
+
+	full_path, err := iconFilePath(icon.Name)
+	if err != nil {
+		return []ConfigError{{path, fmt.Sprintf("icon file not found: %s", icon.Name)}}
+	}
+
+	// Make sure the file is actually an image that can be shown.
+	file, err := os.ReadFile(full_path)
+	if err == nil {
+		_, err = decodeIcon(file)
+	}
+
+	if err != nil {
+		return []ConfigError{{path, fmt.Sprintf("icon file %s can't be used: %s", icon.Name, err.Error())}}
+	}
+
+	return nil
+}
+
+// Walk the raw JSON alongside the config types, reporting any field that
+// does not match a known JSON tag.
+func findUnknownFields(raw any, target reflect.Type, path string) []ConfigError {
+
+	errs := []ConfigError{}
+
+	for target.Kind() == reflect.Pointer {
+		target = target.Elem()
+	}
+
+	switch value := raw.(type) {
+	case map[string]any:
+		if target.Kind() != reflect.Struct {
+			return errs
+		}
+
+		fields := jsonFields(target)
+
+		keys := []string{}
+		for key := range value {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			fieldType, ok := fields[key]
+
+			if !ok {
+				errs = append(errs, ConfigError{fmt.Sprintf("%s.%s", path, key), "unknown field"})
+				continue
+			}
+
+			errs = append(errs, findUnknownFields(value[key], fieldType, fmt.Sprintf("%s.%s", path, key))...)
+		}
+	case []any:
+		if target.Kind() != reflect.Slice {
+			return errs
+		}
+
+		for i, item := range value {
+			errs = append(errs, findUnknownFields(item, target.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	}
+
+	return errs
+}
+
+// Get the type of every JSON field of a struct, including the fields of any
+// embedded structs, which are encoded as if they were part of the outer one.
+func jsonFields(target reflect.Type) map[string]reflect.Type {
+
+	fields := map[string]reflect.Type{}
+
+	for i := 0; i < target.NumField(); i++ {
+		field := target.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			for embedded, fieldType := range jsonFields(field.Type) {
+				fields[embedded] = fieldType
+			}
+
+			continue
+		}
+
+		fields[name] = field.Type
+	}
+
+	return fields
+}
@@ -0,0 +1,118 @@
+package tray
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/adrg/xdg"
+	"golang.org/x/exp/slices"
+)
+
+func writeConfigFile(t *testing.T, dir string, name string, data []byte) {
+
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Join(dir, "kb_ui"), 0755); err != nil {
+		t.Fatalf("failed to make the config folder: %s", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "kb_ui", name), data, 0644); err != nil {
+		t.Fatalf("failed to write %s: %s", name, err)
+	}
+}
+
+func TestValidateConfigFile(t *testing.T) {
+
+	useTempDirs(t)
+
+	// Icons are only looked for next to the config file, as they are loaded,
+	// so one that is only in a system folder is missing.
+	system := filepath.Join(t.TempDir(), "system")
+	t.Setenv("XDG_CONFIG_DIRS", system)
+	xdg.Reload()
+
+	var icon bytes.Buffer
+	png.Encode(&icon, image.NewRGBA(image.Rect(0, 0, 16, 16)))
+
+	writeConfigFile(t, xdg.ConfigHome, "good.png", icon.Bytes())
+	writeConfigFile(t, xdg.ConfigHome, "bad.png", []byte("not an image"))
+	writeConfigFile(t, system, "system.png", icon.Bytes())
+
+	tests := []struct {
+		name   string
+		config string
+		want   []string
+	}{
+		{"valid", `{"layers": ` + testLayers + `}`, nil},
+		{"no icon falls back", `{"layers": [{"name": "Base", "mods": "ctrl", "key": "F1"}]}`, nil},
+		{"icons", `{"layers": [
+			{"name": "Base", "mods": "ctrl", "key": "F1", "icon": "good.png"},
+			{"name": "Nav", "mods": "ctrl", "key": "F2", "icon": "kb_dark"},
+			{"name": "Num", "mods": "ctrl", "key": "F3", "icon": "missing.png"},
+			{"name": "Sym", "mods": "ctrl", "key": "F4", "icon": "bad.png"},
+			{"name": "Fn", "mods": "ctrl", "key": "F5", "icon": "system.png"},
+			{"name": "Mouse", "mods": "ctrl", "key": "F6", "icon": {"text": "TOOLONG"}}
+		]}`, []string{"$.layers[2].icon", "$.layers[3].icon", "$.layers[4].icon", "$.layers[5].icon.text"}},
+		{"invalid JSON", `{"layers": [`, []string{"$"}},
+		{"unknown fields", `{
+			"colour": "red",
+			"layers": [{"name": "Base", "mods": "ctrl", "key": "F1", "icon": {"text": "B", "size": 3}, "behaviour": "to"}],
+			"flags": [{"name": "caps-word", "toggle": {"mods": "ctrl", "key": "F2", "hold": true}}]
+		}`, []string{"$.colour", "$.flags[0].toggle.hold", "$.layers[0].behaviour", "$.layers[0].icon.size"}},
+		{"unknown keyboard field", `{"keyboards": [{"name": "Sofle", "layers": ` + testLayers + `, "leds": "/dev/input/event3"}]}`,
+			[]string{"$.keyboards[0].leds"}},
+		{"layers", `{"layers": [
+			{"name": "Base", "mods": "ctrl", "key": "F1"},
+			{"name": "Base", "mods": "ctrl", "key": "F2"},
+			{"name": "", "mods": "ctlr", "key": "F3"},
+			{"name": "Num", "mods": "ctrl", "key": "F99", "behavior": "hold"},
+			{"name": "Sym", "mods": "ctrl", "key": "F5", "on_enter": [" "]}
+		]}`, []string{"$.layers[1].name", "$.layers[2].mods", "$.layers[2].name", "$.layers[3].behavior", "$.layers[3].key", "$.layers[4].on_enter[0]"}},
+		{"duplicate chords", `{
+			"layers": [
+				{"name": "Base", "mods": "ctrl-shift", "key": "F1"},
+				{"name": "Nav", "mods": "shift-ctrl", "key": "f1"}
+			],
+			"connectMods": "ctrl-shift", "connectKey": "F1",
+			"flags": [{"name": "mouse", "toggle": {"mods": "shift+ctrl", "key": "F1"}}],
+			"outputs": [{"name": "USB", "mods": "ctrl-shift", "key": "F1"}]
+		}`, []string{"$.connectKey", "$.flags[0].toggle.key", "$.layers[1].key", "$.outputs[0].key"}},
+		{"duplicate chords between keyboards", `{"keyboards": [
+			{"name": "Sofle", "layers": ` + testLayers + `},
+			{"name": "Sofle", "layers": ` + testLayers + `}
+		]}`, []string{"$.keyboards[1].layers[0].key", "$.keyboards[1].layers[1].key", "$.keyboards[1].layers[2].key", "$.keyboards[1].name"}},
+		{"combos", `{
+			"layers": [
+				{"name": "Base", "mods": "ctrl", "key": "F1"},
+				{"name": "Nav", "mods": "ctrl", "key": "F2"},
+				{"name": "Mac", "mods": "ctrl", "key": "F3", "group": "os"}
+			],
+			"combos": [
+				{"layers": ["Base", "Mac"], "icon": {"text": "BM"}},
+				{"layers": ["Base", "Nav"], "icon": {"text": "BN"}},
+				{"layers": ["Base", "Gaming"], "icon": {"text": "BG"}},
+				{"layers": [], "icon": {"text": "X"}},
+				{"layers": ["Nav", "Mac"]}
+			]
+		}`, []string{"$.combos[1].layers[1]", "$.combos[2].layers[1]", "$.combos[3].layers", "$.combos[4].icon"}},
+	}
+
+	for _, test := range tests {
+		paths := []string{}
+
+		for _, err := range ValidateConfigFile([]byte(test.config)) {
+			paths = append(paths, err.Path)
+		}
+
+		sort.Strings(paths)
+
+		if !slices.Equal(paths, test.want) && (len(paths) != 0 || len(test.want) != 0) {
+			t.Errorf("%s: expected problems at %v, got %v", test.name, test.want, ValidateConfigFile([]byte(test.config)))
+		}
+	}
+}